@@ -1,9 +1,11 @@
-const MAP_SIZE = 50
+const DEFAULT_MAP_SIZE = 50
+const MAP_VERSION = 1
+const DIFFICULTIES = ["easy", "normal", "hard", "nightmare"]
 
 const data = {
   map: null,
   mode: null,
-  pickerMonster: ["orc", "ghoul", "skeleton", "thing", "wiz", "spectre"],
+  pickerMonster: [],
   pickerWall: [
     "brick_brown_0",
     "brick_brown_2",
@@ -79,9 +81,9 @@ const data = {
     "wall_yellow_rock_0",
     "wall_yellow_rock_1",
   ],
-  pickerItem: ["potion", "crystal", "meat", "apple", "key_green", "key_red", "key_blue", "column", "barrel"],
+  pickerItem: [],
   pickerDoor: ["basic", "key_blue", "key_red", "key_green", "switch"],
-  pickerDeco: ["torch", "blood_1", "blood_2", "slime", "grate", "pipe", "struts", "crack", "switch", "secret", "exit"],
+  pickerDeco: ["torch", "blood_1", "blood_2", "slime", "grate", "pipe", "struts", "crack", "switch", "secret", "exit", "teleport"],
   selectedMonster: 0,
   selectedWall: 0,
   selectedItem: 0,
@@ -93,16 +95,22 @@ const data = {
   loadingSaving: false,
   playerPos: [1, 1],
   floorColour: [1, 1, 1],
+  triggers: [],
   ceilingColour: [1, 1, 1],
+  info: newMapInfo(),
+  width: DEFAULT_MAP_SIZE,
+  height: DEFAULT_MAP_SIZE,
 
-  initApp() {
+  initApp(width = DEFAULT_MAP_SIZE, height = DEFAULT_MAP_SIZE) {
     this.fileHandle = null
     this.fileName = ""
     this.mode = null
-    this.map = new Array(MAP_SIZE)
-    for (let x = 0; x < MAP_SIZE; x++) {
-      this.map[x] = new Array(MAP_SIZE)
-      for (let y = 0; y < MAP_SIZE; y++) {
+    this.width = width
+    this.height = height
+    this.map = new Array(width)
+    for (let x = 0; x < width; x++) {
+      this.map[x] = new Array(height)
+      for (let y = 0; y < height; y++) {
         this.map[x][y] = newEmptyCell(x, y)
       }
     }
@@ -111,6 +119,21 @@ const data = {
     this.map[1][1].v = "0"
     this.ceilingColour = [1, 1, 1]
     this.floorColour = [1, 1, 1]
+    this.triggers = []
+    this.info = newMapInfo()
+  },
+
+  // Monsters & items come from the same definitions files the game uses
+  async loadDefinitions() {
+    try {
+      const monsters = await (await fetch("/data/monsters.json")).json()
+      this.pickerMonster = Object.keys(monsters).map((name) => ({ name, image: (monsters[name].frames || [name])[0] }))
+      const items = await (await fetch("/data/items.json")).json()
+      this.pickerItem = Object.keys(items)
+    } catch (e) {
+      console.error(e)
+      alert("Unable to load monster & item definitions from /data")
+    }
   },
 
   cellClick(x, y, evt) {
@@ -118,7 +141,7 @@ const data = {
     if (evt.type === "click") {
       if (this.mode == "monster") {
         if (this.map[x][y].t == "w" || this.map[x][y].t == "p") return
-        this.map[x][y].v = this.pickerMonster[this.selectedMonster]
+        this.map[x][y].v = this.pickerMonster[this.selectedMonster].name
         this.map[x][y].t = "m"
         return
       }
@@ -137,7 +160,57 @@ const data = {
         return
       }
 
+      // Teleporter pads go on the floor and send the player to another cell
+      if (this.mode == "teleporter") {
+        if (this.map[x][y].t == "w" || this.map[x][y].t == "p") return
+        const current = this.map[x][y].t == "t" ? [this.map[x][y].v, ...this.map[x][y].e].join(" ") : "x,y"
+        const parts = parseTeleporter(prompt("Destination x,y for this teleporter, then optionally a facing 0-3, 'monsters' and/or 'projectiles':", current), true)
+        if (!parts) return
+        this.map[x][y].t = "t"
+        this.map[x][y].v = parts[0]
+        this.map[x][y].e = parts.slice(1)
+        return
+      }
+
       if (this.mode == "extra") {
+        // Monsters can be given orders
+        if (this.map[x][y].t == "m") {
+          const orders = prompt("Orders for this monster, 'ambush' or a patrol route of cells 'x,y x,y ...' (blank for none):", "")
+          if (orders === null) return
+          if (orders.trim() == "") {
+            this.map[x][y].e = []
+          } else if (orders.trim() == "ambush") {
+            this.map[x][y].e = ["ambush"]
+          } else {
+            const cells = orders.trim().split(/\s+/).map((c) => c.split(","))
+            if (cells.some((c) => c.length != 2 || isNaN(parseInt(c[0])) || isNaN(parseInt(c[1])))) {
+              alert("Invalid input, please provide 'ambush' or a list of x,y cells separated by spaces.")
+              return
+            }
+            this.map[x][y].e = ["patrol", ...cells.flat().map((n) => "" + parseInt(n))]
+          }
+          return
+        }
+
+        // Doors can be one way and/or stay open
+        if (this.map[x][y].t == "d") {
+          const current = (this.map[x][y].e || []).join(" ")
+          const flags = prompt("Door flags, 'oneway n|s|e|w' (the side it opens from) and/or 'stayopen' (blank for none):", current)
+          if (flags === null) return
+          const parts = flags.trim().split(/\s+/).filter((f) => f)
+          for (let i = 0; i < parts.length; i++) {
+            if (parts[i] == "stayopen") continue
+            if (parts[i] == "oneway" && ["n", "s", "e", "w"].includes(parts[i + 1])) {
+              i++
+              continue
+            }
+            alert("Invalid input, please provide 'oneway' followed by n, s, e or w, and/or 'stayopen'.")
+            return
+          }
+          this.map[x][y].e = parts
+          return
+        }
+
         // Can only add extras to walls
         if (this.map[x][y].t != "w") return
 
@@ -155,14 +228,29 @@ const data = {
 
         // Adds a switch
         if (this.pickerDeco[this.selectedDeco] == "switch") {
-          const target = prompt("Enter the target cell for this switch:", "x,y")
-          if (!target) return
-          const targetParts = target.split(",")
-          if (targetParts.length != 2 || !parseInt(targetParts[0]) || !parseInt(targetParts[1])) {
-            alert("Invalid input, please provide the x,y coordinates of the target cell.")
+          const current = this.map[x][y].e && this.map[x][y].e[0] == "switch" ? this.map[x][y].e.slice(1).join(" ") : "remove x,y"
+          const actions = prompt("Enter the actions for this switch, e.g. remove 5,3 or open 5,3 6,3 toggle:", current)
+          if (!actions) return
+          const parts = actions.trim().split(/\s+/)
+          // A lone x,y is the original single target form
+          if (parts.length == 1 && parts[0].split(",").length == 2) {
+            parts.unshift("remove")
+          }
+          if (parts.length < 2) {
+            alert("Invalid input, please provide an action and the x,y cells it affects.")
             return
           }
-          this.map[x][y].e = ["switch", targetParts[0], targetParts[1]]
+          this.map[x][y].e = ["switch", ...parts]
+          this.mode = "wall"
+          return
+        }
+
+        // Teleporter walls send the player somewhere when used
+        if (this.pickerDeco[this.selectedDeco] == "teleport") {
+          const current = this.map[x][y].e && this.map[x][y].e[0] == "teleport" ? this.map[x][y].e.slice(1).join(" ") : "x,y"
+          const parts = parseTeleporter(prompt("Destination x,y for this teleporter, then optionally a facing 0-3:", current), false)
+          if (!parts) return
+          this.map[x][y].e = ["teleport", ...parts]
           this.mode = "wall"
           return
         }
@@ -170,6 +258,34 @@ const data = {
         this.map[x][y].e = ["deco", this.pickerDeco[this.selectedDeco]]
         return
       }
+      // Floor and ceiling textures can go on any cell that isn't a wall
+      if (this.mode == "floor" || this.mode == "ceiling" || this.mode == "sky") {
+        if (this.map[x][y].t == "w") return
+        if (this.mode == "floor") this.map[x][y].f = `walls/${this.pickerWall[this.selectedWall]}`
+        if (this.mode == "ceiling") this.map[x][y].c = `walls/${this.pickerWall[this.selectedWall]}`
+        if (this.mode == "sky") this.map[x][y].c = "sky"
+        return
+      }
+
+      // Limit what's in a cell to some difficulties
+      if (this.mode == "difficulty") {
+        if (this.map[x][y].t == "p") return
+        const current = (this.map[x][y].d || []).join(",")
+        const diffs = prompt("Only on these difficulties, comma separated e.g. hard,nightmare (blank for all):", current)
+        if (diffs === null) return
+        const list = diffs
+          .split(",")
+          .map((d) => d.trim().toLowerCase())
+          .filter((d) => d)
+        if (list.some((d) => !DIFFICULTIES.includes(d))) {
+          alert(`Invalid input, difficulties are: ${DIFFICULTIES.join(", ")}`)
+          return
+        }
+        if (list.length > 0) this.map[x][y].d = list
+        else delete this.map[x][y].d
+        return
+      }
+
       if (this.mode == "player") {
         if (this.map[x][y].t == "w") return
 
@@ -198,9 +314,20 @@ const data = {
     }
 
     this.cellTip = `${x},${y} (${(x + 1) * 32 - 16}, ${(y + 1) * 32 - 16})`
+    if (this.map[x][y].d) this.cellTip += ` only on ${this.map[x][y].d.join(", ")}`
   },
 
   cellClear(x, y) {
+    // In floor & ceiling modes only remove the texture
+    if (this.mode == "floor") {
+      delete this.map[x][y].f
+      return
+    }
+    if (this.mode == "ceiling" || this.mode == "sky") {
+      delete this.map[x][y].c
+      return
+    }
+
     if (this.map[x][y].t == "p") {
       return
     }
@@ -231,9 +358,22 @@ const data = {
     if (cell.t == "m") return `url(/gfx/monsters/${cell.v}.png)`
     if (cell.t == "w") return `url(/gfx/walls/${cell.v}.png)`
     if (cell.t == "d") return `url(/gfx/doors/${cell.v}.png)`
+    if (cell.t == "t") return `url(/gfx/effects/teleporter.png)`
+    if (cell.f) return `url(/gfx/${cell.f}.png)`
     return "none"
   },
 
+  // Tooltip showing extras plus any floor & ceiling textures
+  cellTitle(x, y) {
+    const cell = this.getCell(x, y)
+    if (!cell) return ""
+    let title = cell.e.join(",")
+    if (cell.t == "t") title = `teleport to ${cell.v} ${title}`
+    if (cell.f) title += ` floor:${cell.f}`
+    if (cell.c) title += ` ceiling:${cell.c}`
+    return title
+  },
+
   // Used for decorations and extra stuff on walls  like secrets & exits
   getOverlayForCell(x, y) {
     if (!this.map || !this.map[x] || !this.map[x][y]) return "none"
@@ -252,6 +392,9 @@ const data = {
       if (cell.e[0] == "exit") {
         return `url(/gfx/decoration/exit.png)`
       }
+      if (cell.e[0] == "teleport") {
+        return `url(/gfx/decoration/teleport.png)`
+      }
     }
   },
 
@@ -272,6 +415,21 @@ const data = {
       case "p":
         this.mode = "player"
         break
+      case "f":
+        this.mode = "floor"
+        break
+      case "c":
+        this.mode = "ceiling"
+        break
+      case "s":
+        this.mode = "sky"
+        break
+      case "k":
+        this.mode = "difficulty"
+        break
+      case "t":
+        this.mode = "teleporter"
+        break
     }
   },
 
@@ -292,9 +450,14 @@ const data = {
 
       await writable.write(
         JSON.stringify({
+          version: MAP_VERSION,
+          ...this.info,
+          width: this.width,
+          height: this.height,
           cells: this.map,
           floorColour: this.floorColour,
           ceilingColour: this.ceilingColour,
+          triggers: this.triggers,
         })
       )
       await writable.close()
@@ -320,11 +483,26 @@ const data = {
       const data = await file.text()
       try {
         const rawFile = JSON.parse(data)
+        if (rawFile.version > MAP_VERSION) {
+          alert(`Map file version ${rawFile.version} is newer than this editor supports`)
+          this.loadingSaving = false
+          return
+        }
         this.map = rawFile.cells
-        this.floorColour = rawFile.floorColour
-        this.ceilingColour = rawFile.ceilingColour
-        for (let x = 0; x < MAP_SIZE; x++) {
-          for (let y = 0; y < MAP_SIZE; y++) {
+        this.floorColour = rawFile.floorColour || [1, 1, 1]
+        this.ceilingColour = rawFile.ceilingColour || [1, 1, 1]
+        this.triggers = rawFile.triggers || []
+
+        // Older files have no version or metadata, so fill in the defaults
+        this.info = newMapInfo()
+        for (const key of Object.keys(this.info)) {
+          if (rawFile[key] !== undefined) this.info[key] = rawFile[key]
+        }
+        if (!this.info.title) this.info.title = file.name.replace(/\.json$/, "")
+        this.width = rawFile.width || this.map.length
+        this.height = rawFile.height || this.map[0].length
+        for (let x = 0; x < this.width; x++) {
+          for (let y = 0; y < this.height; y++) {
             if (this.map[x][y].t == "p") {
               this.playerPos = [x, y]
             }
@@ -339,6 +517,17 @@ const data = {
     this.loadingSaving = false
   },
 
+  newMap() {
+    const size = prompt("Map size in cells (width,height)", `${DEFAULT_MAP_SIZE},${DEFAULT_MAP_SIZE}`)
+    if (!size) return
+    const [width, height] = size.split(",").map((n) => parseInt(n))
+    if (!width || !height || width < 3 || height < 3) {
+      alert("Invalid size, please provide the width,height of the map.")
+      return
+    }
+    this.initApp(width, height)
+  },
+
   setFloorCeiling() {
     //prompt for colors
     const floor = prompt("Floor colour (r,g,b) 0-1", this.floorColour.join(","))
@@ -352,6 +541,76 @@ const data = {
     // this.floorColour = this.pickerFloor
     // this.ceilingColour = this.pickerCeiling
   },
+
+  setProperties() {
+    const fields = [
+      ["title", "Level title"],
+      ["author", "Author"],
+      ["description", "Description"],
+      ["music", "Music loop (sound name)"],
+      ["nextLevel", "Next level (map name)"],
+      ["script", "Script (name in maps/scripts)"],
+    ]
+    for (const [key, label] of fields) {
+      const val = prompt(label, this.info[key])
+      if (val === null) return
+      this.info[key] = val
+    }
+
+    const par = prompt("Par time in seconds (0 for none)", this.info.parTime)
+    if (par === null) return
+    this.info.parTime = parseInt(par) || 0
+
+    const fog = prompt("Fog colour (r,g,b) 0-1", this.info.fogColour.join(","))
+    if (fog === null) return
+    fog.split(",").forEach((c, i) => {
+      this.info.fogColour[i] = parseFloat(c)
+    })
+  },
+
+  // Triggers are edited as raw JSON, there's too many options for a form
+  setTriggers() {
+    const json = prompt("Triggers (JSON list, see the readme)", JSON.stringify(this.triggers))
+    if (json === null) return
+    try {
+      const triggers = JSON.parse(json || "[]")
+      if (!Array.isArray(triggers)) throw new Error("not a list")
+      this.triggers = triggers
+    } catch (e) {
+      alert(`Invalid triggers: ${e.message}`)
+    }
+  },
+}
+
+function newMapInfo() {
+  return {
+    title: "",
+    author: "",
+    description: "",
+    music: "loop_ambient_1",
+    parTime: 0,
+    nextLevel: "",
+    fogColour: [0, 0, 0],
+    script: "",
+  }
+}
+
+// Check a teleporter's destination & options, returns them split up or null
+function parseTeleporter(input, pad) {
+  if (!input) return null
+  const parts = input.trim().split(/\s+/)
+  const dest = parts[0].split(",")
+  if (dest.length != 2 || isNaN(parseInt(dest[0])) || isNaN(parseInt(dest[1]))) {
+    alert("Invalid input, please provide the destination as x,y.")
+    return null
+  }
+  for (const opt of parts.slice(1)) {
+    if (["0", "1", "2", "3"].includes(opt)) continue
+    if (pad && (opt == "monsters" || opt == "projectiles")) continue
+    alert(pad ? "Invalid input, options are a facing 0-3, 'monsters' and 'projectiles'." : "Invalid input, the only option is a facing 0-3.")
+    return null
+  }
+  return [`${parseInt(dest[0])},${parseInt(dest[1])}`, ...parts.slice(1)]
 }
 
 function newEmptyCell(x, y) {
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An episode made up of several maps played in order
+type Campaign struct {
+	name           string   // File name, used when saving
+	Title          string   `json:"title"`
+	Levels         []string `json:"levels"`         // Map names in the order they are played
+	CarryInventory bool     `json:"carryInventory"` // Keep keys & items between levels, not just health & mana
+}
+
+// ===========================================================
+// Load all the campaigns in the campaigns folder
+// ===========================================================
+func loadCampaigns() []*Campaign {
+	campaigns := []*Campaign{}
+	files, err := filepath.Glob("campaigns/*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, file := range files {
+		c, err := readCampaign(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			log.Printf("WARNING! %v", err)
+			continue
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns
+}
+
+func readCampaign(name string) (*Campaign, error) {
+	data, err := ioutil.ReadFile("./campaigns/" + name + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Campaign{name: name}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("campaign '%s' is not valid JSON: %v", name, err)
+	}
+	if len(c.Levels) == 0 {
+		return nil, fmt.Errorf("campaign '%s' has no levels", name)
+	}
+	if c.Title == "" {
+		c.Title = name
+	}
+	return c, nil
+}
+
+// ===========================================================
+// Start a campaign from its first level
+// ===========================================================
+func (g *Game) startCampaign(c *Campaign) {
+	log.Printf("Starting campaign '%s'", c.Title)
+	g.campaign = c
+	g.campaignLevel = 0
+	g.campaignStats = Stats{}
+	g.campaignTime = 0
+	g.start(c.Levels[0])
+}
+
+// Name of the level that follows this one, either from the campaign or the map itself
+func (g *Game) nextLevelName() string {
+	if g.campaign != nil {
+		if g.campaignLevel+1 < len(g.campaign.Levels) {
+			return g.campaign.Levels[g.campaignLevel+1]
+		}
+		return ""
+	}
+	return g.mapInfo.NextLevel
+}
+
+// Is this the last level of a campaign
+func (g *Game) campaignComplete() bool {
+	return g.campaign != nil && g.nextLevelName() == ""
+}
+
+// ===========================================================
+// Continue from the end of level screen, carrying the player over
+// ===========================================================
+func (g *Game) nextLevel() {
+	next := g.nextLevelName()
+	if next == "" {
+		g.returnToTitleScreen()
+		return
+	}
+
+	health, mana, holding := g.player.health, g.player.mana, g.player.holding
+	if g.campaign != nil {
+		g.campaignLevel++
+	}
+
+	g.start(next)
+	if g.state != GameStateMain {
+		return
+	}
+
+	g.player.health = health
+	g.player.mana = mana
+	if g.campaign != nil && g.campaign.CarryInventory {
+		g.player.holding = holding
+	}
+}
+
+// Add the stats from a finished level to the campaign totals
+func (g *Game) addCampaignStats() {
+	if g.campaign == nil {
+		return
+	}
+	g.campaignStats.add(g.stats)
+	g.campaignTime += g.stats.elapsed(g.ticks).Round(time.Second)
+}
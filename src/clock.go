@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Ebiten calls Update at this fixed rate regardless of the frame rate, the
+// simulation is driven only by these ticks so it runs the same on any machine
+const ticksPerSecond = 60
+
+func ticksToDuration(ticks int) time.Duration {
+	return time.Duration(ticks) * time.Second / ticksPerSecond
+}
+
+func durationToTicks(d time.Duration) int {
+	return int(d * ticksPerSecond / time.Second)
+}
+
+// Something to run later, on the tick clock
+type timer struct {
+	tick int
+	fn   func()
+}
+
+// Run a function after a number of ticks
+func (g *Game) after(ticks int, fn func()) {
+	g.timers = append(g.timers, timer{tick: g.ticks + ticks, fn: fn})
+}
+
+// Run any timers which are due, in the order they were scheduled
+func (g *Game) runTimers() {
+	due := []timer{}
+	pending := g.timers[:0]
+	for _, t := range g.timers {
+		if t.tick <= g.ticks {
+			due = append(due, t)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	g.timers = pending
+
+	for _, t := range due {
+		t.fn()
+	}
+}
+
+// ===========================================================
+// Random numbers all come from the seed. Counting how many have been drawn
+// lets a saved game carry on with exactly the same sequence
+// ===========================================================
+type countingSource struct {
+	src   rand.Source64
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.src.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+// Start the random numbers from a seed, skipping the ones already drawn
+func (g *Game) seedRandom(seed int64, draws uint64) {
+	source := &countingSource{src: rand.NewSource(seed).(rand.Source64)}
+	for source.draws < draws {
+		source.Uint64()
+	}
+	g.seed = seed
+	g.rngSource = source
+	g.rng = rand.New(source)
+}
+
+// IDs are handed out in order, so sorting by them gives a stable order
+func (g *Game) newID() uint64 {
+	g.lastID++
+	return g.lastID
+}
+
+// ===========================================================
+// Go map iteration order is random, anything in the simulation which
+// loops over monsters, projectiles or items must use these instead
+// ===========================================================
+func (g *Game) monsterList() []*Monster {
+	list := make([]*Monster, 0, len(g.monsters))
+	for _, m := range g.monsters {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+func (g *Game) projectileList() []*Projectile {
+	list := make([]*Projectile, 0, len(g.projectiles))
+	for _, p := range g.projectiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+func (g *Game) itemList() []*Item {
+	list := make([]*Item, 0, len(g.items))
+	for _, i := range g.items {
+		list = append(list, i)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// Current version of the demo file format, bump this when it changes
+const demoFileVersion = 1
+
+// How a demo ended
+const (
+	demoComplete = "complete" // Player finished the level
+	demoDead     = "dead"     // Player was killed
+	demoQuit     = "quit"     // Player quit to the title screen
+)
+
+// A recording of a level being played, the seed & difficulty make the level
+// play out the same, so only the player's actions need to be stored
+type Demo struct {
+	Version    int         `json:"version"`
+	MapName    string      `json:"mapName"`
+	Seed       int64       `json:"seed"`
+	Difficulty string      `json:"difficulty"`
+	Start      SavedPlayer `json:"start"`  // Health etc can be carried over from earlier levels
+	Inputs     [][2]uint32 `json:"inputs"` // Runs of ticks with the same actions, as [count, actions]
+	Result     DemoResult  `json:"result"` // How things stood when the demo ended
+
+	file      string
+	recording bool
+	played    DemoResult // How things ended up when played back
+	run       int        // Current run of inputs when playing back
+	runTick   int        // Ticks played so far in the current run
+}
+
+// Checked at the end of playback, if anything differs the demo has desynced
+type DemoResult struct {
+	Outcome string  `json:"outcome"`
+	Ticks   int     `json:"ticks"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Health  int     `json:"health"`
+	Mana    int     `json:"mana"`
+	Kills   int     `json:"kills"`
+	Items   int     `json:"items"`
+	Secrets int     `json:"secrets"`
+	Score   int     `json:"score"`
+	Doors   int     `json:"doors"`
+}
+
+// ===========================================================
+// Start recording the level that's just started
+// ===========================================================
+func (g *Game) recordDemo(file string) {
+	g.demo = &Demo{
+		Version:    demoFileVersion,
+		MapName:    g.mapName,
+		Seed:       g.seed,
+		Difficulty: g.difficultyLevel().name,
+		Inputs:     [][2]uint32{},
+		file:       file,
+		recording:  true,
+	}
+	log.Printf("Recording demo to %s", file)
+}
+
+// Load a demo file ready to be played back
+func loadDemo(file string) (*Demo, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Demo{file: file}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("demo file '%s' is not valid: %v", file, err)
+	}
+	if d.Version != demoFileVersion {
+		return nil, fmt.Errorf("demo file '%s' is version %d, expected %d", file, d.Version, demoFileVersion)
+	}
+	return d, nil
+}
+
+// ===========================================================
+// Start the demo's level and play the demo back over it
+// ===========================================================
+func (g *Game) playDemo(d *Demo) error {
+	difficulty, err := parseDifficulty(d.Difficulty)
+	if err != nil {
+		return err
+	}
+
+	g.seed = d.Seed
+	g.difficulty = difficulty
+	g.campaign = nil
+	g.start(d.MapName)
+	if g.state != GameStateMain {
+		return fmt.Errorf("demo map '%s' failed to load", d.MapName)
+	}
+
+	g.player.health = d.Start.Health
+	g.player.mana = d.Start.Mana
+	g.player.holding = map[string]int{}
+	for name, count := range d.Start.Holding {
+		g.player.holding[name] = count
+	}
+	if d.Start.Weapon != "" {
+		g.player.weapon = d.Start.Weapon
+	}
+
+	g.demo = d
+	log.Printf("Playing demo %s, map '%s', %d ticks", d.file, d.MapName, d.length())
+	return nil
+}
+
+// Total number of ticks in the demo
+func (d *Demo) length() int {
+	ticks := 0
+	for _, run := range d.Inputs {
+		ticks += int(run[0])
+	}
+	return ticks
+}
+
+// ===========================================================
+// Called every tick with the player's actions, when recording these are
+// stored, when playing back they're replaced with the recorded ones.
+// Returns false when playback has run out of inputs
+// ===========================================================
+func (d *Demo) step(g *Game, actions uint32) (uint32, bool) {
+	if d.recording {
+		// The start is taken on the first tick, after anything carried over has been set
+		if len(d.Inputs) == 0 {
+			d.Start = g.player.saved()
+		}
+
+		last := len(d.Inputs) - 1
+		if last >= 0 && d.Inputs[last][1] == actions {
+			d.Inputs[last][0]++
+		} else {
+			d.Inputs = append(d.Inputs, [2]uint32{1, actions})
+		}
+		return actions, true
+	}
+
+	for d.run < len(d.Inputs) && d.runTick >= int(d.Inputs[d.run][0]) {
+		d.run++
+		d.runTick = 0
+	}
+	if d.run >= len(d.Inputs) {
+		return 0, false
+	}
+	d.runTick++
+	return d.Inputs[d.run][1], true
+}
+
+// How things stand right now, to compare with the end of a demo
+func (g *Game) demoResult(outcome string) DemoResult {
+	return DemoResult{
+		Outcome: outcome,
+		Ticks:   g.ticks,
+		X:       g.player.x,
+		Y:       g.player.y,
+		Health:  g.player.health,
+		Mana:    g.player.mana,
+		Kills:   g.stats.kills,
+		Items:   g.stats.itemsFound,
+		Secrets: g.stats.secretsFound,
+		Score:   g.stats.score,
+		Doors:   g.stats.doorsOpened,
+	}
+}
+
+// Did playback end up the same as the recording
+func (d *Demo) matches() bool {
+	return d.played == d.Result
+}
+
+// ===========================================================
+// Stop any demo when the level ends, recordings are written out and
+// playback is checked against the recorded result
+// ===========================================================
+func (g *Game) stopDemo(outcome string) {
+	d := g.demo
+	if d == nil {
+		return
+	}
+	g.demo = nil
+	result := g.demoResult(outcome)
+
+	if d.recording {
+		d.Result = result
+		data, err := json.Marshal(d)
+		if err == nil {
+			err = ioutil.WriteFile(d.file, data, 0644)
+		}
+		if err != nil {
+			log.Printf("ERROR! Failed to write demo: %v", err)
+			return
+		}
+		log.Printf("Demo saved to %s, %d ticks, %s", d.file, d.length(), outcome)
+		return
+	}
+
+	d.played = result
+	if !d.matches() {
+		log.Printf("WARNING! Demo %s desynced, expected %+v got %+v", d.file, d.Result, result)
+		g.showMessage("Demo desynced!")
+		return
+	}
+	log.Printf("Demo %s played back OK, %d ticks, %s", d.file, result.Ticks, outcome)
+	g.showMessage("Demo finished")
+}
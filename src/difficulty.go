@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// How monsters & items are scaled at each difficulty, 1 is unchanged
+type Difficulty struct {
+	name             string
+	title            string
+	monsterHealth    float64
+	monsterDamage    float64 // Melee & projectile damage
+	projectileChance float64 // How often monsters fire
+	monsterSpeed     float64
+	itemAmount       float64 // Health & mana given by items
+}
+
+var difficulties = []Difficulty{
+	{name: "easy", title: "Easy", monsterHealth: 0.6, monsterDamage: 0.5, projectileChance: 0.6, monsterSpeed: 0.8, itemAmount: 1.5},
+	{name: "normal", title: "Normal", monsterHealth: 1, monsterDamage: 1, projectileChance: 1, monsterSpeed: 1, itemAmount: 1},
+	{name: "hard", title: "Hard", monsterHealth: 1.3, monsterDamage: 1.5, projectileChance: 1.3, monsterSpeed: 1.15, itemAmount: 0.8},
+	{name: "nightmare", title: "Nightmare", monsterHealth: 1.6, monsterDamage: 2, projectileChance: 1.8, monsterSpeed: 1.3, itemAmount: 0.6},
+}
+
+const defaultDifficulty = 1
+
+// Find a difficulty by name, returns its index
+func parseDifficulty(name string) (int, error) {
+	names := []string{}
+	for i, d := range difficulties {
+		if d.name == strings.ToLower(name) {
+			return i, nil
+		}
+		names = append(names, d.name)
+	}
+	return 0, fmt.Errorf("unknown difficulty '%s', should be one of %v", name, names)
+}
+
+func (g *Game) difficultyLevel() Difficulty {
+	if g.difficulty < 0 || g.difficulty >= len(difficulties) {
+		return difficulties[defaultDifficulty]
+	}
+	return difficulties[g.difficulty]
+}
+
+// Scale an amount by a difficulty multiplier
+func scaleInt(amount int, scale float64) int {
+	return int(math.Round(float64(amount) * scale))
+}
+
+// Cells can be limited to some difficulties, with none listed they're always present
+func (c *MapFileCell) presentOn(difficulty string) bool {
+	if len(c.Difficulties) == 0 {
+		return true
+	}
+	for _, d := range c.Difficulties {
+		if d == difficulty {
+			return true
+		}
+	}
+	return false
+}
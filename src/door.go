@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+)
+
+type DoorState int
+
+const (
+	DoorStateClosed DoorState = iota
+	DoorStateOpening
+	DoorStateOpen
+	DoorStateClosing
+)
+
+const doorSlideTicks = 30                // How long a door takes to slide open or closed
+const doorOpenTicks = ticksPerSecond * 4 // How long a door stays open before closing
+
+// Doors slide open when used and close again by themselves, they are drawn
+// recessed into the middle of their cell
+type Door struct {
+	x, y       int
+	kind       string
+	state      DoorState
+	open       float64 // How far open the door is, 0.0 ~ 1.0
+	timer      int     // Ticks until an open door starts to close
+	locked     bool    // Needs a key, once unlocked it stays unlocked
+	stayOpen   bool    // Never closes once opened
+	held       bool    // Held open by a switch until it's switched off
+	oneWay     string  // Side the door can be opened from: n, s, e or w, blank for both
+	horizontal bool    // Runs east to west, i.e. the walls either side are to the east & west
+}
+
+// ===========================================================
+// Create a door, basic doors open for anyone, key doors need the key with
+// the same name and any other kind can never be opened by the player
+// ===========================================================
+func newDoor(x, y int, kind string) *Wall {
+	door := &Door{
+		x:      x,
+		y:      y,
+		kind:   kind,
+		locked: kind != "basic",
+	}
+
+	return &Wall{
+		x:      x,
+		y:      y,
+		image:  imageCache["doors/"+kind],
+		isDoor: true,
+		door:   door,
+		actionFunc: func(g *Game) {
+			door.use(g)
+		},
+	}
+}
+
+// Door flags go in the extras, e.g. ["oneway", "n"] or ["stayopen"] or both
+func parseDoorFlags(extra []string) (oneWay string, stayOpen bool, err error) {
+	for i := 0; i < len(extra); i++ {
+		switch extra[i] {
+		case "stayopen":
+			stayOpen = true
+		case "oneway":
+			if i+1 >= len(extra) {
+				return "", false, fmt.Errorf("oneway needs the side it opens from: n, s, e or w")
+			}
+			i++
+			oneWay = extra[i]
+			if oneWay != "n" && oneWay != "s" && oneWay != "e" && oneWay != "w" {
+				return "", false, fmt.Errorf("oneway side '%s' is not n, s, e or w", oneWay)
+			}
+		default:
+			return "", false, fmt.Errorf("unknown door flag '%s'", extra[i])
+		}
+	}
+	return oneWay, stayOpen, nil
+}
+
+// The cell next to another on a side: n, s, e or w
+func sideCell(x, y int, side string) [2]int {
+	switch side {
+	case "n":
+		return [2]int{x, y - 1}
+	case "s":
+		return [2]int{x, y + 1}
+	case "e":
+		return [2]int{x + 1, y}
+	case "w":
+		return [2]int{x - 1, y}
+	}
+	return [2]int{x, y}
+}
+
+// Doors sit between two walls, work out which way it runs once the map is loaded
+func (d *Door) setOrientation(g *Game) {
+	solid := func(x, y int) bool {
+		return g.inBounds(x, y) && g.mapdata[x][y] != nil && g.mapdata[x][y].door == nil
+	}
+	switch {
+	case solid(d.x-1, d.y) && solid(d.x+1, d.y):
+		d.horizontal = true
+	case solid(d.x, d.y-1) && solid(d.x, d.y+1):
+		d.horizontal = false
+	default:
+		d.horizontal = true
+	}
+}
+
+// Open far enough to walk through
+func (d *Door) isOpen() bool {
+	return d.state == DoorStateOpen
+}
+
+// Centre of the door in world space
+func (d *Door) center() (float64, float64) {
+	return float64(d.x*cellSize + cellSize/2), float64(d.y*cellSize + cellSize/2)
+}
+
+// Is a point on the side of the door it can be opened from
+func (d *Door) fromOpenSide(x, y float64) bool {
+	cx, cy := d.center()
+	switch d.oneWay {
+	case "n":
+		return y < cy
+	case "s":
+		return y > cy
+	case "e":
+		return x > cx
+	case "w":
+		return x < cx
+	}
+	return true
+}
+
+// ===========================================================
+// The player uses the door, unlocking it first if they have the key
+// ===========================================================
+func (d *Door) use(g *Game) {
+	if d.state == DoorStateOpen || d.state == DoorStateOpening {
+		return
+	}
+
+	if !d.fromOpenSide(g.player.x, g.player.y) {
+		playSound("locked", 1.0, false)
+		g.showMessage("This door opens from the other side")
+		return
+	}
+
+	if d.locked {
+		if !isKey(d.kind) || g.player.holding[d.kind] <= 0 {
+			playSound("locked", 1.0, false)
+			return
+		}
+		g.player.holding[d.kind]--
+		d.locked = false
+		playSound("unlock", 1.0, false)
+	}
+
+	d.startOpening(g)
+}
+
+// Start sliding open, this is also used by switches
+func (d *Door) startOpening(g *Game) {
+	if d.state == DoorStateClosed {
+		g.stats.doorsOpened++
+	}
+	d.state = DoorStateOpening
+	playSound("door_open", 0.4, false)
+	cx, cy := d.center()
+	g.makeNoise(cx, cy, noiseDoor)
+}
+
+// Switches hold doors open, unlocking them if needed
+func (d *Door) holdOpen(g *Game) {
+	d.locked = false
+	d.held = true
+	if d.state == DoorStateClosed || d.state == DoorStateClosing {
+		d.startOpening(g)
+	}
+}
+
+// Let go of a door held open by a switch, it closes like any other door
+func (d *Door) release() {
+	d.held = false
+	d.timer = doorOpenTicks
+}
+
+// Start sliding closed, updateDoors will stop it if anything is in the way
+func (d *Door) startClosing() {
+	d.held = false
+	if d.state == DoorStateOpen || d.state == DoorStateOpening {
+		d.state = DoorStateClosing
+		playSound("door_open", 0.3, false)
+	}
+}
+
+// Anything standing in the doorway stops the door closing
+func (d *Door) blocked(g *Game) bool {
+	return g.cellOccupied(d.x, d.y)
+}
+
+// ===========================================================
+// Slide doors open & closed, called every tick
+// ===========================================================
+func (g *Game) updateDoors() {
+	for _, d := range g.doors {
+		switch d.state {
+		case DoorStateOpening:
+			d.open += 1.0 / doorSlideTicks
+			if d.open >= 1 {
+				d.open = 1
+				d.state = DoorStateOpen
+				d.timer = doorOpenTicks
+			}
+
+		case DoorStateOpen:
+			if d.stayOpen || d.held {
+				continue
+			}
+			if d.timer--; d.timer > 0 {
+				continue
+			}
+			if d.blocked(g) {
+				d.timer = ticksPerSecond / 2
+				continue
+			}
+			d.state = DoorStateClosing
+			playSound("door_open", 0.3, false)
+
+		case DoorStateClosing:
+			// Never shut on anything, go back the other way
+			if d.blocked(g) {
+				d.state = DoorStateOpening
+				continue
+			}
+			d.open -= 1.0 / doorSlideTicks
+			if d.open <= 0 {
+				d.open = 0
+				d.state = DoorStateClosed
+			}
+		}
+	}
+}
+
+// ===========================================================
+// Where a ray passing through the door's cell hits the door, if it does.
+// The door is a thin wall across the middle of the cell, the open part
+// has slid away to the side so rays pass straight through it
+// ===========================================================
+func (d *Door) rayHit(x, y, dirX, dirY, entryDist float64) (dist, texU float64, side WallSide, ok bool) {
+	cx, cy := d.center()
+	var along float64
+	if d.horizontal {
+		if dirY == 0 {
+			return 0, 0, 0, false
+		}
+		dist = (cy - y) / dirY
+		along = (x+dirX*dist)/cellSize - float64(d.x)
+		side = WallSideNorth
+		if dirY < 0 {
+			side = WallSideSouth
+		}
+	} else {
+		if dirX == 0 {
+			return 0, 0, 0, false
+		}
+		dist = (cx - x) / dirX
+		along = (y+dirY*dist)/cellSize - float64(d.y)
+		side = WallSideWest
+		if dirX < 0 {
+			side = WallSideEast
+		}
+	}
+
+	// The ray leaves the cell before reaching the door, or goes through the open part
+	if dist < entryDist || along < d.open || along >= 1 {
+		return 0, 0, 0, false
+	}
+	return dist, along - d.open, side, true
+}
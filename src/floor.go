@@ -0,0 +1,110 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Used when a cell's ceiling is open to the sky
+const skyCeiling = "sky"
+
+var skyColour = []uint8{28, 38, 70}
+
+// Floor & ceiling textures for a single map cell, nil means use the flat tinted backdrop
+type Surface struct {
+	floor   *image.RGBA
+	ceiling *image.RGBA
+	sky     bool
+}
+
+func (g *Game) initFloorCast() {
+	g.floorCastWidth = viewRays
+	g.floorCastHeight = winHeight / int(viewRaysRatio)
+	g.floorCastImage = ebiten.NewImage(g.floorCastWidth, g.floorCastHeight)
+	g.floorCastPixels = make([]byte, g.floorCastWidth*g.floorCastHeight*4)
+}
+
+// ===========================================================
+// Perspective floor & ceiling casting, only cells with textures are drawn,
+// the rest is left transparent so the tinted backdrop shows through
+// ===========================================================
+func (g *Game) castFloorCeiling(screen *ebiten.Image) {
+	if !g.hasSurfaces {
+		return
+	}
+
+	// Precompute the direction of each column's ray
+	rayCos := make([]float64, g.floorCastWidth)
+	raySin := make([]float64, g.floorCastWidth)
+	rayFix := make([]float64, g.floorCastWidth)
+	for i := 0; i < g.floorCastWidth; i++ {
+		rayAngle := g.player.rayAngleAt(float64(i) * float64(winWidth) / float64(g.floorCastWidth))
+		rayCos[i] = math.Cos(rayAngle)
+		raySin[i] = math.Sin(rayAngle)
+		rayFix[i] = math.Cos(rayAngle - g.player.angle)
+	}
+
+	rowScale := float64(winHeight) / float64(g.floorCastHeight)
+	for row := 0; row < g.floorCastHeight; row++ {
+		// Distance from the horizon in screen pixels, negative is the ceiling
+		p := (float64(row)+0.5)*rowScale - float64(winHeightHalf)
+		isFloor := p > 0
+
+		// Inverse of the wall height projection, gives the distance to this row
+		rowDist := cellSize * projDist / (2 * math.Abs(p))
+
+		for i := 0; i < g.floorCastWidth; i++ {
+			offset := (row*g.floorCastWidth + i) * 4
+			g.floorCastPixels[offset+3] = 0
+
+			dist := rowDist / rayFix[i]
+			if dist > viewDistance || math.Abs(p) < 1 {
+				continue
+			}
+
+			wx := g.player.x + rayCos[i]*dist
+			wy := g.player.y + raySin[i]*dist
+			cellX := int(math.Floor(wx / cellSize))
+			cellY := int(math.Floor(wy / cellSize))
+			if !g.inBounds(cellX, cellY) {
+				continue
+			}
+
+			surface := g.surfaces[cellX][cellY]
+			tex := surface.ceiling
+			if isFloor {
+				tex = surface.floor
+			} else if surface.sky {
+				g.floorCastPixels[offset] = skyColour[0]
+				g.floorCastPixels[offset+1] = skyColour[1]
+				g.floorCastPixels[offset+2] = skyColour[2]
+				g.floorCastPixels[offset+3] = 0xff
+				continue
+			}
+			if tex == nil {
+				continue
+			}
+
+			// Sample the texture and darken it with distance, same as the walls
+			size := tex.Bounds().Size()
+			tx := int((wx/cellSize - float64(cellX)) * float64(size.X))
+			ty := int((wy/cellSize - float64(cellY)) * float64(size.Y))
+			texOffset := tex.PixOffset(tex.Bounds().Min.X+tx, tex.Bounds().Min.Y+ty)
+
+			distScale := 1 - (dist / viewDistance)
+			distScale = distScale * distScale * 1.5
+			fog := math.Max(0, 1-distScale)
+			for c := 0; c < 3; c++ {
+				g.floorCastPixels[offset+c] = uint8(math.Min(float64(tex.Pix[texOffset+c])*distScale*g.light+g.mapInfo.FogColour[c]*fog*0xff, 0xff))
+			}
+			g.floorCastPixels[offset+3] = 0xff
+		}
+	}
+
+	g.floorCastImage.ReplacePixels(g.floorCastPixels)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(float64(winWidth)/float64(g.floorCastWidth), rowScale)
+	screen.DrawImage(g.floorCastImage, op)
+}
@@ -5,6 +5,7 @@ import (
 	"image"
 	"log"
 	"math"
+	"math/rand"
 	"os"
 	"sort"
 	"time"
@@ -27,15 +28,80 @@ const (
 // Holds most core game data
 type Game struct {
 	mapdata     [][]*Wall              // Map data is stored in a 2D array, 0 = empty, 1+ = wall
+	mapWidth    int                    // Map width in cells, from the map file
+	mapHeight   int                    // Map height in cells, from the map file
+	surfaces    [][]Surface            // Floor & ceiling textures, same layout as mapdata
+	hasSurfaces bool                   // Skip floor casting when the map has no textured cells
 	player      Player                 // Player object
 	sprites     []*Sprite              // All sprites on the map, used for depth sorting
 	monsters    map[uint64]*Monster    // Monsters on the map
 	projectiles map[uint64]*Projectile // Projectiles currently in the game
 	items       map[uint64]*Item       // Items currently in the game
+	doors       []*Door                // All doors, in the order they were loaded
+	switches    []*Switch              // All switches, in the order they were loaded
+	light       float64                // Light level of the whole map, switches can change it
+	triggers    []*Trigger             // Scripted events from the map file
+	triggerCell [2]int                 // Player's cell when the triggers were last checked
+	teleporters map[[2]int]*Teleporter // Teleporter pads, keyed by their cell
+	script      *Script                // Level script, nil when the map doesn't have one
 	ticks       int                    // Tick count
 	mapName     string
+	mapInfo     MapInfo // Title, music and other level metadata
 	state       GameState
 	stats       Stats
+
+	campaign      *Campaign // Campaign being played, nil when playing a single level
+	campaignLevel int       // Index of the current level in the campaign
+	campaignStats Stats     // Totals for all completed levels in the campaign
+	campaignTime  time.Duration
+
+	message      string // Message shown on the HUD
+	messageTimer int    // Ticks left to show the message for
+
+	difficulty int // Index into difficulties
+
+	titleLevelIndex int    // Campaign or level selected on the title screen
+	titleSaveSlot   string // Most recent save, offered on the title screen
+	saveSlotIndex   int    // Slot selected in the pause menu
+
+	// The simulation only uses these, so it plays out the same given the same seed & inputs
+	seed      int64
+	rng       *rand.Rand
+	rngSource *countingSource
+	timers    []timer
+	lastID    uint64
+	input     Input // Actions for the current tick
+
+	demo       *Demo  // Demo being recorded or played back, if any
+	recordFile string // The next level started is recorded to this demo file
+
+	// Drawing state, every game has its own so they can be drawn independently
+	headless        bool                     // No window, graphics or sound, only the simulation is run
+	frameBuffer     *ebiten.Image            // Everything is drawn here first, then scaled to fit the window
+	depthBuffer     []float64                // Used for rendering sprites with occlusion
+	hudImage        *ebiten.Image            // HUD image cache
+	forceHudUpdate  bool                     // Redraw the HUD next frame rather than waiting
+	overlayImage    *ebiten.Image            // Sized to the map when it is loaded
+	overlayZoom     float64                  // Scale of the map overlay
+	overlayShown    bool                     // Map overlay is toggled with tab
+	floorOp         *ebiten.DrawImageOptions // Precomputed for drawing the floor backdrop
+	ceilOp          *ebiten.DrawImageOptions // Precomputed for drawing the ceiling backdrop
+	floorCastImage  *ebiten.Image
+	floorCastPixels []byte
+	floorCastWidth  int // Size of the low resolution buffer the floor & ceiling are cast into
+	floorCastHeight int
+	flashTimer      int
+	flashColor      []float64
+}
+
+func newGame(seed int64, difficulty int) *Game {
+	return &Game{
+		state:       GameStateTitle,
+		seed:        seed,
+		difficulty:  difficulty,
+		overlayZoom: 5.0,
+		flashColor:  []float64{1, 1, 1, 0.8},
+	}
 }
 
 // ===========================================================
@@ -43,25 +109,38 @@ type Game struct {
 // ===========================================================
 func (g *Game) start(mapName string) {
 	playSound("menu_start", 2, false)
-	playSoundLoop("loop_ambient_1", 1)
 
 	log.Printf("Starting level...")
 	g.sprites = make([]*Sprite, 0)
 	g.monsters = make(map[uint64]*Monster, 0)
 	g.projectiles = make(map[uint64]*Projectile, 0)
 	g.items = make(map[uint64]*Item, 0)
+	g.doors = nil
+	g.switches = nil
+	g.triggers = nil
+	g.teleporters = map[[2]int]*Teleporter{}
+	g.script = nil
+	g.light = 1
+	g.ticks = 0
+	g.timers = nil
+	g.lastID = 0
+	g.seedRandom(g.seed, 0)
+	g.input = Input{}
 	g.stats = Stats{}
 	g.stats.init()
-	g.stats.startTime = time.Now()
+	g.stats.startTick = g.ticks
 
 	g.player = newPlayer(1, 1)
 
 	// Precompute operations for drawing floor and ceiling
-	floorOp = &ebiten.DrawImageOptions{}
-	floorOp.GeoM.Scale(float64(winWidth)/10.0, float64(winHeightHalf)/600.0)
-	floorOp.GeoM.Translate(0.0, float64(winHeightHalf))
-	ceilOp = &ebiten.DrawImageOptions{}
-	ceilOp.GeoM.Scale(float64(winWidth)/10.0, float64(winHeightHalf)/600.0)
+	g.floorOp = &ebiten.DrawImageOptions{}
+	g.floorOp.GeoM.Scale(float64(winWidth)/10.0, float64(winHeightHalf)/600.0)
+	g.floorOp.GeoM.Translate(0.0, float64(winHeightHalf))
+	g.ceilOp = &ebiten.DrawImageOptions{}
+	g.ceilOp.GeoM.Scale(float64(winWidth)/10.0, float64(winHeightHalf)/600.0)
+	if !g.headless {
+		g.initFloorCast()
+	}
 
 	g.mapName = mapName
 	err := g.loadMap(mapName)
@@ -70,16 +149,24 @@ func (g *Game) start(mapName string) {
 		g.returnToTitleScreen()
 		return
 	}
-	log.Printf("Map level '%s' loaded", g.mapName)
+	log.Printf("Map level '%s' loaded: %s", g.mapName, g.mapInfo.Title)
+	playSoundLoop(g.mapInfo.Music, 1)
 
 	g.state = GameStateMain
 
+	if g.recordFile != "" {
+		g.recordDemo(g.recordFile)
+		g.recordFile = ""
+	}
+
 	if debug {
 		log.Printf("Player: %+v", g.player)
 		log.Printf("Level stats: %+v", g.stats)
 	}
 	// HUD image cache
-	hudImage = ebiten.NewImage(winWidth, winHeight)
+	if !g.headless {
+		g.hudImage = ebiten.NewImage(winWidth, winHeight)
+	}
 }
 
 // ===========================================================
@@ -89,39 +176,64 @@ func (g *Game) Update() error {
 	if g.state == GameStateTitle {
 		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) ||
 			inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
-			g.start(titleLevels[titleLevelIndex])
+			if g.titleLevelIndex < len(titleCampaigns) {
+				g.startCampaign(titleCampaigns[g.titleLevelIndex])
+			} else {
+				g.campaign = nil
+				g.start(titleLevels[g.titleLevelIndex-len(titleCampaigns)])
+			}
+		}
+
+		if inpututil.IsKeyJustPressed(ebiten.KeyL) && g.titleSaveSlot != "" {
+			if err := g.loadGame(g.titleSaveSlot); err != nil {
+				log.Printf("ERROR! Failed to load game: %v", err)
+				g.returnToTitleScreen()
+			}
 		}
 
 		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
 			os.Exit(0)
 		}
 
+		// Campaigns are listed first, then all the single levels
+		titleEntries := len(titleCampaigns) + len(titleLevels)
 		if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
-			titleLevelIndex = (titleLevelIndex + 1) % len(titleLevels)
+			g.titleLevelIndex = (g.titleLevelIndex + 1) % titleEntries
 			playSound("menu_click", 1, false)
 		}
 
 		if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
-			titleLevelIndex--
-			if titleLevelIndex < 0 {
-				titleLevelIndex = len(titleLevels) - 1
+			g.titleLevelIndex--
+			if g.titleLevelIndex < 0 {
+				g.titleLevelIndex = titleEntries - 1
 			}
 			playSound("menu_click", 1, false)
 		}
 
+		if inpututil.IsKeyJustPressed(ebiten.KeyUp) && g.difficulty < len(difficulties)-1 {
+			g.difficulty++
+			playSound("menu_click", 1, false)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyDown) && g.difficulty > 0 {
+			g.difficulty--
+			playSound("menu_click", 1, false)
+		}
+
 		return nil
 	}
 
 	if g.state == GameStateGameOver || g.state == GameStateEndLevel {
 		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeySpace) ||
 			inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
-			g.returnToTitleScreen()
+			if g.state == GameStateEndLevel {
+				g.nextLevel()
+			} else {
+				g.returnToTitleScreen()
+			}
 		}
 		return nil
 	}
 
-	g.ticks++
-
 	if g.state == GameStatePaused {
 		if inpututil.IsKeyJustPressed(ebiten.KeyQ) {
 			g.returnToTitleScreen()
@@ -129,171 +241,260 @@ func (g *Game) Update() error {
 		if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
 			g.state = GameStateMain
 		}
+
+		// Save & load to the selected slot
+		if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+			g.saveSlotIndex = (g.saveSlotIndex + 1) % len(saveSlots)
+			playSound("menu_click", 1, false)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+			g.saveSlotIndex = (g.saveSlotIndex + len(saveSlots) - 1) % len(saveSlots)
+			playSound("menu_click", 1, false)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyS) {
+			g.quickSave(saveSlots[g.saveSlotIndex])
+			g.state = GameStateMain
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+			g.quickLoad(saveSlots[g.saveSlotIndex])
+		}
 		return nil
 	}
 
+	if inpututil.IsKeyJustPressed(ebiten.KeyF5) {
+		g.quickSave(quickSaveSlot)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyF9) {
+		g.quickLoad(quickSaveSlot)
+	}
+
 	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
 		g.state = GameStatePaused
 	}
 
+	// Demos replace the player's input, or record it
+	actions := readActions()
+	if g.demo != nil {
+		var ok bool
+		if actions, ok = g.demo.step(g, actions); !ok {
+			g.stopDemo(demoQuit)
+			g.returnToTitleScreen()
+			return nil
+		}
+	}
+
+	// The simulation only moves on when not paused
+	g.step(actions)
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		g.overlayShown = !g.overlayShown
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) {
+		g.overlayZoom -= 0.3
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) {
+		g.overlayZoom += 0.3
+	}
+
+	return nil
+}
+
+// ===========================================================
+// Move the simulation on one tick with the player's actions, this doesn't
+// touch the keyboard or screen so it can also be run headless
+// ===========================================================
+func (g *Game) step(actions uint32) {
+	g.ticks++
+	g.runTimers()
+
+	if g.messageTimer > 0 {
+		g.messageTimer--
+	}
+
+	in := &g.input
+	in.update(actions)
+
+	if g.script != nil && !g.script.started {
+		g.script.started = true
+		g.runScript("onStart")
+	}
+
 	// Update rest of game state
+	g.player.updateBuffs()
+	g.updateDoors()
+	g.updateSwitches()
 	g.updateMonsters()
 	g.updateProjectiles()
 
 	// When move keys are first pressed, reset the acceleration timer
-	if inpututil.IsKeyJustPressed(ebiten.KeyUp) || inpututil.IsKeyJustPressed(ebiten.KeyDown) ||
-		inpututil.IsKeyJustPressed(ebiten.KeyW) || inpututil.IsKeyJustPressed(ebiten.KeyS) {
-		g.player.moveStartTime = time.Now().UnixMicro()
+	if in.isPressed(actionForward | actionBack) {
+		g.player.moveStartTick = g.ticks
 	}
 	// Now handle the actual move as long as move keys are held
-	if ebiten.IsKeyPressed(ebiten.KeyUp) || ebiten.IsKeyPressed(ebiten.KeyW) {
-		g.player.move(time.Now().UnixMicro()-g.player.moveStartTime, -1, 0)
+	if in.isHeld(actionForward) {
+		g.player.move(g, g.ticks-g.player.moveStartTick, -1, 0)
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyDown) || ebiten.IsKeyPressed(ebiten.KeyS) {
-		g.player.move(time.Now().UnixMicro()-g.player.moveStartTime, +1, 0)
+	if in.isHeld(actionBack) {
+		g.player.move(g, g.ticks-g.player.moveStartTick, +1, 0)
 	}
 
 	// When turn keys are first pressed, reset the acceleration timer
-	if inpututil.IsKeyJustPressed(ebiten.KeyRight) || inpututil.IsKeyJustPressed(ebiten.KeyD) || inpututil.IsKeyJustPressed(ebiten.KeyLeft) || inpututil.IsKeyJustPressed(ebiten.KeyA) {
-		if ebiten.IsKeyPressed(ebiten.KeyAlt) {
-			g.player.moveStartTime = time.Now().UnixMicro()
+	if in.isPressed(actionLeft | actionRight) {
+		if in.isHeld(actionStrafe) {
+			g.player.moveStartTick = g.ticks
 		} else {
-			g.player.turnStartTime = time.Now().UnixMicro()
+			g.player.turnStartTick = g.ticks
 		}
 	}
 	// Now handle the actual turn as long as turn keys are held
-	if ebiten.IsKeyPressed(ebiten.KeyLeft) || ebiten.IsKeyPressed(ebiten.KeyA) {
-		if ebiten.IsKeyPressed(ebiten.KeyAlt) {
-			g.player.move(time.Now().UnixMicro()-g.player.moveStartTime, +1, -1)
+	if in.isHeld(actionLeft) {
+		if in.isHeld(actionStrafe) {
+			g.player.move(g, g.ticks-g.player.moveStartTick, +1, -1)
 		} else {
-			g.player.turn(time.Now().UnixMicro()-g.player.turnStartTime, -1)
+			g.player.turn(g.ticks-g.player.turnStartTick, -1)
 		}
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyRight) || ebiten.IsKeyPressed(ebiten.KeyD) {
-		if ebiten.IsKeyPressed(ebiten.KeyAlt) {
-			g.player.move(time.Now().UnixMicro()-g.player.moveStartTime, +1, +1)
+	if in.isHeld(actionRight) {
+		if in.isHeld(actionStrafe) {
+			g.player.move(g, g.ticks-g.player.moveStartTick, +1, +1)
 		} else {
-			g.player.turn(time.Now().UnixMicro()-g.player.turnStartTime, +1)
+			g.player.turn(g.ticks-g.player.turnStartTick, +1)
 		}
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
-		g.player.use()
+	if in.isPressed(actionUse) {
+		g.player.use(g)
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyShift) {
-		g.player.attack()
+	// Holding fire attacks as fast as the weapon allows
+	if in.isHeld(actionAttack) {
+		g.player.attack(g)
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
-		overlayShown = !overlayShown
+	// Select weapons with the number keys or mouse wheel
+	for i := range slotKeys {
+		if in.isPressed(actionSlot1 << i) {
+			for _, def := range weaponOrder {
+				if def.Slot == i+1 {
+					g.player.selectWeapon(g, def.name)
+				}
+			}
+		}
 	}
-
-	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) {
-		overlayZoom -= 0.3
+	if in.isHeld(actionPrevWeapon) {
+		g.player.nextWeapon(g, -1)
+	} else if in.isHeld(actionNextWeapon) {
+		g.player.nextWeapon(g, 1)
 	}
 
-	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) {
-		overlayZoom += 0.3
-	}
-
-	return nil
+	// Triggers & the script see where everything ended up this tick
+	g.updateTriggers()
+	g.runScript("onTick", float64(g.ticks))
 }
 
 // ===========================================================
-// Main draw function
+// Main draw function, renders into the framebuffer then scales it to the window
 // ===========================================================
 func (g *Game) Draw(screen *ebiten.Image) {
+	if g.frameBuffer == nil {
+		g.frameBuffer = ebiten.NewImage(winWidth, winHeight)
+		g.depthBuffer = make([]float64, viewRays)
+	}
+	g.frameBuffer.Clear()
+	g.drawFrame(g.frameBuffer)
+
+	// Fit to the window keeping the aspect ratio, letterboxing any space left over
+	screenW, screenH := screen.Size()
+	scale := math.Min(float64(screenW)/float64(winWidth), float64(screenH)/float64(winHeight))
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(scale, scale)
+	op.GeoM.Translate((float64(screenW)-float64(winWidth)*scale)/2, (float64(screenH)-float64(winHeight)*scale)/2)
+	screen.DrawImage(g.frameBuffer, op)
+}
+
+func (g *Game) drawFrame(screen *ebiten.Image) {
 	if g.state == GameStateTitle {
-		renderTitle(screen)
+		renderTitle(screen, g)
 		return
 	}
 
 	if g.state == GameStateGameOver {
-		renderGameOver(screen)
+		renderGameOver(screen, g)
 		return
 	}
 
 	if g.state == GameStateEndLevel {
-		renderEndOfLevel(screen)
+		renderEndOfLevel(screen, g)
 		return
 	}
 
-	// Render the ceiling and floor
-	screen.DrawImage(imageCache["other/floor"], floorOp)
-	screen.DrawImage(imageCache["other/ceil"], ceilOp)
+	// Render the ceiling and floor, dimmed when a switch has changed the light
+	floorOp, ceilOp := *g.floorOp, *g.ceilOp
+	floorOp.ColorM.Scale(g.light, g.light, g.light, 1)
+	ceilOp.ColorM.Scale(g.light, g.light, g.light, 1)
+	screen.DrawImage(imageCache["other/floor"], &floorOp)
+	screen.DrawImage(imageCache["other/ceil"], &ceilOp)
+	g.castFloorCeiling(screen)
 
 	// Cast rays to render player's view
 	for i := 0; i < viewRays; i++ {
-		rayAngle := g.player.angle - g.player.fov/2 + g.player.fov*float64(i)/float64(viewRays)
-
-		// Initialize ray and depth buffer
-		t := 0.0
-		depthBuffer[i] = viewDistance
-
-		// Main ray loop
-		for t = 0.0; t < viewDistance; t += rayStepT {
-			// Get hit point
-			cx := g.player.x + (t * math.Cos(rayAngle))
-			cy := g.player.y + (t * math.Sin(rayAngle))
-
-			// Detect collision with walls
-			wall := g.getWallAt(cx, cy)
-			if wall == nil || wall.invisible {
-				continue
-			}
+		rayAngle := g.player.rayAngleAt(float64(i) * viewRaysRatio)
 
-			// If wall was hit...
-			wall.seen = true
+		g.depthBuffer[i] = viewDistance
 
-			// Texture mapping
-			hitx := cx/cellSize - math.Floor(cx/cellSize)
-			hity := cy/cellSize - math.Floor(cy/cellSize)
-			texColumn := int(hitx * cellSize)
-			if hitx < epi || hitx > 1-epi {
-				texColumn = int(hity * cellSize)
-			}
-
-			// Get wall texture column at the hit point
-			textureColStrip := wall.image.SubImage(image.Rect(texColumn, 0, texColumn+1, textureSize)).(*ebiten.Image)
-
-			// Handle decorations
-			var decoStrip *ebiten.Image
-			if wall.decoration != nil {
-				if wall != nil && g.ticks%20 < 10 && len(wall.metadata) > 1 && wall.metadata[1] == "torch" {
-					wall.decoration = imageCache["decoration/torch-1"]
-				} else if wall != nil && len(wall.metadata) > 1 && wall.metadata[1] == "torch" {
-					wall.decoration = imageCache["decoration/torch"]
-				}
-				decoStrip = wall.decoration.SubImage(image.Rect(texColumn, 0, texColumn+1, textureSize)).(*ebiten.Image)
-			}
+		hit, ok := g.castRay(g.player.x, g.player.y, rayAngle, viewDistance, false)
+		if !ok {
+			continue
+		}
 
-			// Scale the height of rendered wall strip to the distance and correct fish-eye effect
-			// This is the heart of the 3D effect in the game
-			colHeight := (float64(winHeight) / t) * magicWall / (math.Cos(rayAngle - g.player.angle))
+		// If wall was hit...
+		wall := hit.wall
+		wall.seen = true
 
-			// Scale and place the strip
-			op := &ebiten.DrawImageOptions{}
-			op.GeoM.Scale(viewRaysRatio, colHeight/textureSize)
-			op.GeoM.Translate(float64(i)*float64(viewRaysRatio), float64(winHeightHalf)-colHeight/2)
+		// Texture mapping
+		texColumn := int(hit.texU * textureSize)
+		if texColumn >= textureSize {
+			texColumn = textureSize - 1
+		}
 
-			// Darken as we go further
-			distScale := 1 - (t / viewDistance)
-			distScale = distScale * distScale * 1.5 // The last part brightens the textures a bit
+		// Get wall texture column at the hit point
+		textureColStrip := wall.image.SubImage(image.Rect(texColumn, 0, texColumn+1, textureSize)).(*ebiten.Image)
 
-			// Draw the strip
-			op.ColorM.Scale(distScale, distScale, distScale, 1)
-			screen.DrawImage(textureColStrip, op)
-			if decoStrip != nil {
-				screen.DrawImage(decoStrip, op)
+		// Handle decorations
+		var decoStrip *ebiten.Image
+		if wall.decoration != nil {
+			if g.ticks%20 < 10 && len(wall.metadata) > 1 && wall.metadata[1] == "torch" {
+				wall.decoration = imageCache["decoration/torch-1"]
+			} else if len(wall.metadata) > 1 && wall.metadata[1] == "torch" {
+				wall.decoration = imageCache["decoration/torch"]
 			}
+			decoStrip = wall.decoration.SubImage(image.Rect(texColumn, 0, texColumn+1, textureSize)).(*ebiten.Image)
+		}
 
-			// Save depth in buffer
-			depthBuffer[i] = t
+		// Scale the height of rendered wall strip to the distance and correct fish-eye effect
+		// This is the heart of the 3D effect in the game
+		colHeight := cellSize * projDist / (hit.dist * math.Cos(rayAngle-g.player.angle))
 
-			// Important to stop!
-			break
+		// Scale and place the strip
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(viewRaysRatio, colHeight/textureSize)
+		op.GeoM.Translate(float64(i)*float64(viewRaysRatio), float64(winHeightHalf)-colHeight/2)
+
+		// Darken as we go further
+		distScale := 1 - (hit.dist / viewDistance)
+		distScale = distScale * distScale * 1.5 // The last part brightens the textures a bit
+
+		// Draw the strip
+		g.applyFog(&op.ColorM, distScale)
+		screen.DrawImage(textureColStrip, op)
+		if decoStrip != nil {
+			screen.DrawImage(decoStrip, op)
 		}
+
+		// Save depth in buffer
+		g.depthBuffer[i] = hit.dist
 	}
 
 	// Sprite rendering loop(s)...
@@ -318,86 +519,139 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	g.overlay(screen)
 
 	if debug {
-		msg := fmt.Sprintf("FPS: %0.2f\nPlayer: %f,%f,%f\nHolding: %+v\nLevel: %s\nVer: %s", ebiten.CurrentFPS(), g.player.x, g.player.y, g.player.angle, g.player.holding, g.mapName, Version)
+		msg := fmt.Sprintf("FPS: %0.2f\nPlayer: %f,%f,%f\nHolding: %+v\nLevel: %s (%s)\nVer: %s", ebiten.CurrentFPS(), g.player.x, g.player.y, g.player.angle, g.player.holding, g.mapName, g.mapInfo.Title, Version)
 		ebitenutil.DebugPrint(screen, msg)
 	}
 
 	// For screen flash effects
-	if flashTimer > 0 {
-		flashTimer--
+	if g.flashTimer > 0 {
+		g.flashTimer--
 		op := &ebiten.DrawImageOptions{}
 		op.GeoM.Scale(float64(winWidth), float64(winHeight))
-		op.ColorM.Scale(flashColor[0], flashColor[1], flashColor[2], flashColor[3])
+		op.ColorM.Scale(g.flashColor[0], g.flashColor[1], g.flashColor[2], g.flashColor[3])
 		screen.DrawImage(imageCache["effects/flash"], op)
 	}
 
 	renderHud(screen, g)
 
 	if g.state == GameStatePaused {
-		renderPauseScreen(screen)
+		renderPauseScreen(screen, g)
 	}
 }
 
+// ===========================================================
+// Darken a colour with distance, fading towards the map's fog colour
+// ===========================================================
+func (g *Game) applyFog(cm *ebiten.ColorM, distScale float64) {
+	fog := math.Max(0, math.Min(1-distScale, 1))
+	cm.Scale(distScale*g.light, distScale*g.light, distScale*g.light, 1)
+	cm.Translate(g.mapInfo.FogColour[0]*fog, g.mapInfo.FogColour[1]*fog, g.mapInfo.FogColour[2]*fog, 0)
+}
+
 // ===========================================================
 // Required by ebiten
 // ===========================================================
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-	return winWidth, winHeight
+	// Screen matches the window, the framebuffer is scaled to fit in Draw
+	return outsideWidth, outsideHeight
 }
 
 // ===========================================================
 // Collision detection with map cells
 // ===========================================================
 func (g *Game) getWallAt(x, y float64) *Wall {
-	// NOTE: Bounds checking is not done, the map must have an outer wall
-	mapCellX := int(x / cellSize)
-	mapCellY := int(y / cellSize)
-	if mapCellX < 0 || mapCellY < 0 || mapCellX >= mapSize || mapCellY >= mapSize {
+	mapCellX := int(math.Floor(x / cellSize))
+	mapCellY := int(math.Floor(y / cellSize))
+	if !g.inBounds(mapCellX, mapCellY) {
 		return nil
 	}
 
-	return g.mapdata[mapCellX][mapCellY]
+	// Doors only let things through once fully open
+	wall := g.mapdata[mapCellX][mapCellY]
+	if wall != nil && wall.door != nil && wall.door.isOpen() {
+		return nil
+	}
+	return wall
+}
+
+// Check if a cell is inside the map
+func (g *Game) inBounds(cellX, cellY int) bool {
+	return cellX >= 0 && cellY >= 0 && cellX < g.mapWidth && cellY < g.mapHeight
 }
 
 func (g *Game) returnToTitleScreen() {
 	log.Printf("Entering title screen")
+	g.stopDemo(demoQuit)
+	g.titleSaveSlot = latestSaveSlot()
 	playSoundLoop("loop_menu", 0.5)
 	g.state = GameStateTitle
-	hudImage = nil
+	g.hudImage = nil
+}
+
+// Show a message on the HUD for a few seconds
+func (g *Game) showMessage(msg string) {
+	g.message = msg
+	g.messageTimer = messageTicks
+	g.forceHudUpdate = true
+}
+
+func (g *Game) quickSave(slot string) {
+	if g.demo != nil {
+		g.showMessage("Can't save during a demo")
+		return
+	}
+	if err := g.saveGame(slot); err != nil {
+		log.Printf("ERROR! Failed to save game: %v", err)
+		g.showMessage("Save failed!")
+		return
+	}
+	playSound("menu_click", 1, false)
+	g.showMessage(fmt.Sprintf("Game saved to slot %s", slot))
+}
+
+func (g *Game) quickLoad(slot string) {
+	if g.demo != nil {
+		g.showMessage("Can't load during a demo")
+		return
+	}
+	if err := g.loadGame(slot); err != nil {
+		log.Printf("ERROR! Failed to load game: %v", err)
+		g.showMessage("Unable to load game")
+		return
+	}
+	g.showMessage(fmt.Sprintf("Game loaded from slot %s", slot))
 }
 
 func (g *Game) gameOver() {
 	log.Printf("Game over! :(")
 	playSoundLoop("loop_gameover", 0.6)
 	g.state = GameStateGameOver
-	hudImage = nil
+	g.hudImage = nil
+	g.stopDemo(demoDead)
 }
 
 func (g *Game) endLevel() {
 	log.Printf("Level complete!")
 	playSoundLoop("loop_end", 0.6)
 	g.state = GameStateEndLevel
-	hudImage = nil
-	g.stats.endTime = time.Now()
+	g.hudImage = nil
+	g.stats.endTick = g.ticks
+	g.addCampaignStats()
+	g.stopDemo(demoComplete)
 }
 
-func fireRayAt(x1, y1 float64, x2, y2 float64, maxDist float64) (wall *Wall, dist float64, angle float64) {
+// Fire a ray from one point towards another, returning the first wall hit (if any)
+func (g *Game) fireRayAt(x1, y1 float64, x2, y2 float64, maxDist float64) (wall *Wall, dist float64, angle float64) {
 	newAngle := math.Atan2(y2-y1, x2-x1)
 
-	w, d := fireRayAngle(x1, y1, newAngle, maxDist)
+	w, d := g.fireRayAngle(x1, y1, newAngle, maxDist)
 	return w, d, newAngle
 }
 
-func fireRayAngle(x, y float64, angle float64, maxDist float64) (w *Wall, d float64) {
-	// Fire a ray in the direction we're facing
-	for t := 0.0; t < maxDist; t += rayStepT {
-		// Get hit point
-		cx := x + (t * math.Cos(angle))
-		cy := y + (t * math.Sin(angle))
-		// Detect collision with walls
-		if wall := game.getWallAt(cx, cy); wall != nil {
-			return wall, t
-		}
+// Fire a ray at an angle, any wall (including invisible ones) will stop it
+func (g *Game) fireRayAngle(x, y float64, angle float64, maxDist float64) (w *Wall, d float64) {
+	if hit, ok := g.castRay(x, y, angle, maxDist, true); ok {
+		return hit.wall, hit.dist
 	}
 	return nil, maxDist
 }
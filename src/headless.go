@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// ===========================================================
+// Create a game for a level without a window, graphics or sound. It's moved
+// on a tick at a time with step() and can be inspected directly
+// ===========================================================
+func newHeadlessGame(mapName string, seed int64, difficulty int) (*Game, error) {
+	g := newGame(seed, difficulty)
+	g.headless = true
+	g.start(mapName)
+	if g.state != GameStateMain {
+		return nil, fmt.Errorf("map '%s' failed to load", mapName)
+	}
+	return g, nil
+}
+
+// Still playing the level, i.e. not dead or at the exit
+func (g *Game) isRunning() bool {
+	return g.state == GameStateMain
+}
+
+// Step for a number of ticks with the same actions, stops early if the level ends
+func (g *Game) run(ticks int, actions uint32) {
+	for i := 0; i < ticks && g.isRunning(); i++ {
+		g.step(actions)
+	}
+}
+
+// ===========================================================
+// Play a demo from start to finish headless, returns false if it desynced
+// ===========================================================
+func runHeadlessDemo(d *Demo) (bool, error) {
+	g := newGame(0, defaultDifficulty)
+	g.headless = true
+	if err := g.playDemo(d); err != nil {
+		return false, err
+	}
+
+	for g.demo != nil {
+		actions, ok := d.step(g, 0)
+		if !ok {
+			g.stopDemo(demoQuit)
+			break
+		}
+		g.step(actions)
+	}
+	return d.matches(), nil
+}
+
+// ===========================================================
+// The simulate subcommand, plays demos back headless and checks they end
+// the same as when recorded, or runs a level for a while with no input.
+// The exit code is non-zero if any demo desynced or a level failed to run
+// ===========================================================
+func runSimulate(args []string) int {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	level := flags.String("level", "", "Run this level/map with no input rather than playing demos")
+	ticks := flags.Int("ticks", ticksPerSecond*60, "How many ticks to run the level for")
+	seed := flags.Int64("seed", 1, "Random seed for the level")
+	difficultyName := flags.String("difficulty", "normal", "Difficulty: easy, normal, hard or nightmare")
+	_ = flags.Parse(args)
+
+	if err := loadDefinitions(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if *level != "" {
+		difficulty, err := parseDifficulty(*difficultyName)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		g, err := newHeadlessGame(*level, *seed, difficulty)
+		if err != nil {
+			fmt.Printf("%s: %v\n", *level, err)
+			return 1
+		}
+		g.run(*ticks, 0)
+		fmt.Printf("%s: %s\n", *level, g.summary())
+		return 0
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Println("simulate needs one or more demo files, or -level")
+		return 1
+	}
+
+	exitCode := 0
+	for _, file := range flags.Args() {
+		d, err := loadDemo(file)
+		if err == nil {
+			var ok bool
+			if ok, err = runHeadlessDemo(d); err == nil && !ok {
+				err = fmt.Errorf("DESYNCED\n  - expected %+v\n  - got      %+v", d.Result, d.played)
+			}
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Printf("%s: OK, %s after %d ticks\n", file, d.played.Outcome, d.played.Ticks)
+	}
+
+	return exitCode
+}
+
+// One line description of where the level has got to
+func (g *Game) summary() string {
+	return fmt.Sprintf("%d ticks, player at %.1f,%.1f with %d health, %d/%d monsters killed, %d/%d items, %d doors opened",
+		g.ticks, g.player.x/cellSize, g.player.y/cellSize, g.player.health, g.stats.kills, g.stats.monsters,
+		g.stats.itemsFound, g.stats.itemsTotal, g.stats.doorsOpened)
+}
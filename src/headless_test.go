@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Press an action for one tick then let go, like tapping a key
+func tap(g *Game, action uint32) {
+	g.step(action)
+	g.step(0)
+}
+
+func TestWalkStopsAtWall(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#######",
+		"#P....#",
+		"#######",
+	}))
+
+	g.run(ticksPerSecond*5, actionForward)
+
+	if g.player.cellX != 5 || g.player.cellY != 1 {
+		t.Fatalf("player should have walked to the end of the corridor at 5,1, got to %d,%d", g.player.cellX, g.player.cellY)
+	}
+	if g.player.x+g.player.size > 6*cellSize {
+		t.Errorf("player at x %.1f has walked into the wall", g.player.x)
+	}
+}
+
+func TestTurnAndStrafe(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#####",
+		"#P..#",
+		"#...#",
+		"#...#",
+		"#####",
+	}))
+
+	angle := g.player.angle
+	g.run(10, actionRight)
+	if g.player.angle <= angle {
+		t.Errorf("turning right should increase the angle, was %.2f now %.2f", angle, g.player.angle)
+	}
+
+	g.player.setFacing(1)
+	g.run(ticksPerSecond*3, actionStrafe|actionRight)
+	if g.player.cellX != 1 || g.player.cellY != 3 {
+		t.Errorf("strafing right while facing east should end up at 1,3, got %d,%d", g.player.cellX, g.player.cellY)
+	}
+}
+
+func TestDoorOpensAndCloses(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#####",
+		"#PD.#",
+		"#####",
+	}))
+	door := g.doorAt(2, 1)
+
+	// Can't walk through a closed door
+	g.run(ticksPerSecond, actionForward)
+	if g.player.cellX != 1 {
+		t.Fatalf("player walked through a closed door to %d,%d", g.player.cellX, g.player.cellY)
+	}
+
+	tap(g, actionUse)
+	g.run(doorSlideTicks, 0)
+	if !door.isOpen() || g.stats.doorsOpened != 1 {
+		t.Fatalf("door should be open after being used, state %v", door.state)
+	}
+
+	g.run(ticksPerSecond, actionForward)
+	if g.player.cellX != 3 {
+		t.Fatalf("player should have walked through the open door to 3,1, got to %d,%d", g.player.cellX, g.player.cellY)
+	}
+
+	g.run(doorOpenTicks+doorSlideTicks, 0)
+	if door.state != DoorStateClosed {
+		t.Errorf("door should have closed by itself, state %v", door.state)
+	}
+}
+
+func TestKeyPickupOpensDoor(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#######",
+		"#PkR..#",
+		"#######",
+	}))
+	door := g.doorAt(3, 1)
+
+	// Not holding the key yet
+	tap(g, actionUse)
+	g.run(doorSlideTicks, 0)
+	if door.isOpen() {
+		t.Fatal("key door opened without the key")
+	}
+
+	g.run(ticksPerSecond, actionForward)
+	if g.player.holding["key_red"] != 1 || len(g.items) != 0 || g.stats.itemsFound != 1 {
+		t.Fatalf("key should have been picked up, holding %v with %d items left", g.player.holding, len(g.items))
+	}
+
+	tap(g, actionUse)
+	g.run(doorSlideTicks, 0)
+	if !door.isOpen() {
+		t.Fatal("key door should open with the key")
+	}
+	if g.player.holding["key_red"] != 0 {
+		t.Errorf("opening the door should use up the key, holding %v", g.player.holding)
+	}
+}
+
+func TestFurnitureBlocks(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"######",
+		"#P.b.#",
+		"######",
+	}))
+
+	g.run(ticksPerSecond*2, actionForward)
+	if g.player.cellX != 2 || len(g.items) != 1 {
+		t.Errorf("barrel should block the player and not be picked up, player at %d,%d", g.player.cellX, g.player.cellY)
+	}
+}
+
+func TestKillMonster(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#########",
+		"#P.....o#",
+		"#########",
+	}))
+	if len(g.monsters) != 1 || g.stats.monsters != 1 {
+		t.Fatalf("expected one monster, got %d", len(g.monsters))
+	}
+
+	for i := 0; i < ticksPerSecond*10 && len(g.monsters) > 0; i++ {
+		g.step(actionAttack)
+	}
+	if len(g.monsters) != 0 || g.stats.kills != 1 {
+		t.Fatalf("monster should have been killed, %d left", len(g.monsters))
+	}
+	if g.player.health <= 0 || !g.isRunning() {
+		t.Errorf("player should have survived, health %d", g.player.health)
+	}
+}
+
+func TestExitEndsLevel(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"####",
+		"#PX#",
+		"####",
+	}))
+
+	tap(g, actionUse)
+	if g.state != GameStateEndLevel || g.isRunning() {
+		t.Fatalf("using the exit should end the level, state %v", g.state)
+	}
+
+	// Nothing else happens once the level is over
+	ticks := g.ticks
+	g.run(100, actionForward)
+	if g.ticks != ticks {
+		t.Errorf("run should stop when the level has ended, ran %d more ticks", g.ticks-ticks)
+	}
+}
+
+func TestSameSeedSameGame(t *testing.T) {
+	mapFile := testMap([]string{
+		"##########",
+		"#P.......#",
+		"#.....o..#",
+		"#..o.....#",
+		"##########",
+	})
+
+	games := []*Game{testGame(t, mapFile), testGame(t, mapFile)}
+	for _, g := range games {
+		for i := 0; i < ticksPerSecond*5; i++ {
+			g.step(actionAttack | actionLeft)
+		}
+	}
+	if a, b := games[0].summary(), games[1].summary(); a != b {
+		t.Errorf("two runs with the same seed differ:\n%s\n%s", a, b)
+	}
+}
+
+func TestGamesLoadSideBySide(t *testing.T) {
+	cells := []*MapFileCell{}
+	for x := 1; x < 4; x++ {
+		cells = append(cells, &MapFileCell{X: x, Y: 1, Floor: "walls/brick_dark_0", Ceiling: "walls/catacombs_0"})
+	}
+	path := writeTestMap(t, testMap([]string{
+		"#####",
+		"#...#",
+		"#####",
+	}, append(cells, &MapFileCell{X: 1, Y: 1, Type: "p", Value: "1", Floor: "walls/brick_dark_0"})...))
+
+	// Games share the texture cache, so loading at the same time must be safe
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			g, err := newHeadlessGame(path, 1, defaultDifficulty)
+			if err == nil {
+				g.run(ticksPerSecond, actionForward)
+				if g.player.cellX != 3 || !g.hasSurfaces {
+					err = fmt.Errorf("game ended up at %d,%d", g.player.cellX, g.player.cellY)
+				}
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestDoorWontCloseOnPlayer(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#####",
+		"#PD.#",
+		"#####",
+	}))
+	door := g.doorAt(2, 1)
+	tap(g, actionUse)
+	g.run(doorSlideTicks, 0)
+
+	// Still in the cell before the door, but overlapping the doorway
+	g.player.x = 2*cellSize - g.player.size/2
+	g.player.cellX = 1
+	g.run((doorOpenTicks+doorSlideTicks)*2, 0)
+	if door.state == DoorStateClosed {
+		t.Errorf("door closed on the player standing at x %.1f", g.player.x)
+	}
+}
+
+func TestDamageBuffOnlyForProjectiles(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#####",
+		"#Po.#",
+		"#####",
+	}))
+	mon := g.monsterList()[0]
+	g.player.buffs[buffDamage] = ticksPerSecond * 10
+
+	g.player.weapon = "staff"
+	health := mon.health
+	g.player.attack(g)
+	if lost := health - mon.health; lost != weaponDefs["staff"].Damage {
+		t.Errorf("staff with the damage buff did %d damage, expected %d", lost, weaponDefs["staff"].Damage)
+	}
+
+	g.player.weapon = "bolt"
+	g.player.lastAttack = -1000
+	g.player.attack(g)
+	for _, proj := range g.projectileList() {
+		if proj.damage != weaponDefs["bolt"].Damage*2 {
+			t.Errorf("bolt with the damage buff does %d damage, expected double", proj.damage)
+		}
+	}
+	if len(g.projectiles) == 0 {
+		t.Error("bolt should have been fired")
+	}
+}
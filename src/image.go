@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"image"
+	"image/draw"
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -11,6 +15,11 @@ import (
 
 var imageCache map[string]*ebiten.Image
 
+// CPU side copies of images, used where we need to read pixels such as floor casting.
+// Games share these as they're never changed once loaded, but can load them at the same time
+var textureCache = map[string]*image.RGBA{}
+var textureLock sync.Mutex
+
 const gfxDir = "./gfx"
 
 func loadImageCache() {
@@ -44,3 +53,39 @@ func loadImageCache() {
 		}
 	}
 }
+
+// Load an image from the gfx directory as raw RGBA pixels, results are cached
+func loadTexture(name string) (*image.RGBA, error) {
+	textureLock.Lock()
+	defer textureLock.Unlock()
+	if tex, ok := textureCache[name]; ok {
+		return tex, nil
+	}
+
+	file, err := os.Open(gfxDir + "/" + name + ".png")
+	if err != nil {
+		return nil, fmt.Errorf("texture not found: %s", name)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tex := image.NewRGBA(img.Bounds())
+	draw.Draw(tex, tex.Bounds(), img, img.Bounds().Min, draw.Src)
+	textureCache[name] = tex
+	return tex, nil
+}
+
+// Check an image exists, this works without the image cache so can be used headless
+func imageExists(name string) bool {
+	if imageCache != nil {
+		_, ok := imageCache[name]
+		return ok
+	}
+
+	_, err := os.Stat(gfxDir + "/" + name + ".png")
+	return err == nil
+}
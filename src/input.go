@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// Game actions, each is one bit of the input state for a tick
+const (
+	actionForward uint32 = 1 << iota
+	actionBack
+	actionLeft
+	actionRight
+	actionStrafe
+	actionUse
+	actionAttack
+	actionNextWeapon
+	actionPrevWeapon
+	actionSlot1 // Slots 2-9 follow on from this bit
+)
+
+// Keys for each action, any of them can be used
+var actionKeys = map[uint32][]ebiten.Key{
+	actionForward: {ebiten.KeyUp, ebiten.KeyW},
+	actionBack:    {ebiten.KeyDown, ebiten.KeyS},
+	actionLeft:    {ebiten.KeyLeft, ebiten.KeyA},
+	actionRight:   {ebiten.KeyRight, ebiten.KeyD},
+	actionStrafe:  {ebiten.KeyAlt},
+	actionUse:     {ebiten.KeySpace},
+	actionAttack:  {ebiten.KeyShift},
+}
+
+var slotKeys = []ebiten.Key{ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4, ebiten.Key5, ebiten.Key6, ebiten.Key7, ebiten.Key8, ebiten.Key9}
+
+// Everything the player does in the game for one tick, this is all that's
+// needed to play a level out again so it's what demos record
+type Input struct {
+	held uint32 // Actions held this tick
+	prev uint32 // Actions held last tick
+}
+
+// ===========================================================
+// Read the keyboard & mouse into a set of actions
+// ===========================================================
+func readActions() uint32 {
+	actions := uint32(0)
+	for action, keys := range actionKeys {
+		for _, key := range keys {
+			if ebiten.IsKeyPressed(key) {
+				actions |= action
+			}
+		}
+	}
+	for i, key := range slotKeys {
+		if ebiten.IsKeyPressed(key) {
+			actions |= actionSlot1 << i
+		}
+	}
+
+	if _, wheel := ebiten.Wheel(); wheel > 0 {
+		actions |= actionPrevWeapon
+	} else if wheel < 0 {
+		actions |= actionNextWeapon
+	}
+	return actions
+}
+
+// Move on to the next tick's actions
+func (in *Input) update(actions uint32) {
+	in.prev = in.held
+	in.held = actions
+}
+
+// Any of the actions are held this tick
+func (in *Input) isHeld(actions uint32) bool {
+	return in.held&actions != 0
+}
+
+// Any of the actions have just been pressed this tick
+func (in *Input) isPressed(actions uint32) bool {
+	return in.held&^in.prev&actions != 0
+}
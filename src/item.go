@@ -1,83 +1,70 @@
 package main
 
 import (
-	"math/rand"
+	"log"
 )
 
 type Item struct {
 	id         uint64
+	def        *ItemDef
 	sprite     *Sprite
-	pickUpFunc func(*Player)
+	pickUpFunc func(*Game)
 	cellX      int
 	cellY      int
+	dropped    bool // Left behind by a monster, rather than placed in the map
 }
 
-func (g *Game) addItem(kind string, cellX, cellY int) {
+func (g *Game) addItem(kind string, cellX, cellY int) *Item {
+	def := itemDefs[kind]
+	if def == nil {
+		log.Printf("ERROR! Unknown item '%s' at %d,%d", kind, cellX, cellY)
+		return nil
+	}
+
 	x := float64(cellX)*cellSize + cellSize/2
 	y := float64(cellY)*cellSize + cellSize/2
 	s := g.addSprite("items/"+kind, x, y, 0, 0, cellSize/16.0)
 
-	id := rand.Uint64()
+	id := g.newID()
 	item := &Item{
 		id:         id,
+		def:        def,
 		sprite:     s,
 		cellX:      cellX,
 		cellY:      cellY,
-		pickUpFunc: func(p *Player) {},
-	}
-
-	if kind == "potion" {
-		item.pickUpFunc = func(p *Player) {
-			p.mana += 25
-			playSound("potion_get", 1, false)
-		}
+		pickUpFunc: def.pickUp,
 	}
 
-	if kind == "crystal" {
-		item.pickUpFunc = func(p *Player) {
-			p.mana += 50
-			playSound("zip_up", 1, false)
-		}
-	}
-
-	if kind == "meat" {
-		item.pickUpFunc = func(p *Player) {
-			p.health += 25
-			playSound("yum", 1, false)
-		}
+	// Very special case, these aren't items at all, but dungeon "furniture" which act like walls
+	if def.Effect == effectFurniture {
+		g.mapdata[cellX][cellY] = newInvisibleWall(cellX, cellY)
 	}
 
-	if kind == "apple" {
-		item.pickUpFunc = func(p *Player) {
-			p.health += 10
-			playSound("gulp", 1, false)
-		}
+	g.items[id] = item
+	if def.Counts {
+		g.stats.itemsTotal++
 	}
+	return item
+}
 
-	if kind == "key_red" || kind == "key_blue" || kind == "key_green" {
-		item.pickUpFunc = func(p *Player) {
-			p.holding[kind]++
-			playSound("key_up", 1, false)
-		}
+// Items dropped by monsters when they are killed
+func (g *Game) addDrop(kind string, cellX, cellY int) {
+	if isFurniture(kind) {
+		log.Printf("WARNING! Furniture '%s' can't be dropped by monsters", kind)
+		return
 	}
-
-	// Very special case, these aren't items at all, but dungeon "furniture" which act like walls
-	if kind == "column" || kind == "barrel" {
-		item.pickUpFunc = func(p *Player) {
-		}
-		game.mapdata[cellX][cellY] = newInvisibleWall(cellX, cellY)
-		g.stats.itemsTotal--
+	if item := g.addItem(kind, cellX, cellY); item != nil {
+		item.dropped = true
 	}
-
-	g.items[id] = item
-	g.stats.itemsTotal++
 }
 
 func (g *Game) removeItem(i *Item) {
-	screenFlashWhite(5)
+	g.screenFlashWhite(5)
+	if i.def.Counts {
+		g.stats.itemsFound++
+	}
 	delete(g.items, i.id)
 	g.removeSprite(i.sprite)
 	i.sprite = nil
 	i = nil
-	g.stats.itemsFound++
 }
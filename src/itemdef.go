@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+const itemDefsFile = "./data/items.json"
+
+// All item types, keyed by name as used in map files
+var itemDefs map[string]*ItemDef
+
+// What an item does when picked up, loaded from the item definitions file
+type ItemDef struct {
+	name     string
+	Effect   string `json:"effect"`
+	Amount   int    `json:"amount"`   // Health, mana or score given
+	Sound    string `json:"sound"`    // Played when picked up
+	Icon     string `json:"icon"`     // Image shown on the HUD while held, only for keys & weapons
+	Counts   bool   `json:"counts"`   // Counts toward the items found stats
+	Buff     string `json:"buff"`     // Which buff is given
+	Duration int    `json:"duration"` // How many ticks a buff lasts
+	Weapon   string `json:"weapon"`   // Which weapon is given
+}
+
+// Effects an item can have
+const (
+	effectHealth    = "health"    // Restore health
+	effectMana      = "mana"      // Restore mana
+	effectKey       = "key"       // Held by the player and used to open doors with the same name
+	effectWeapon    = "weapon"    // Gives the player a weapon
+	effectBuff      = "buff"      // Temporary buff
+	effectFurniture = "furniture" // Blocks movement like a wall and can't be picked up
+	effectScore     = "score"     // Adds to the score
+)
+
+// Temporary buffs
+const (
+	buffSpeed  = "speed"  // Move faster
+	buffDamage = "damage" // Double damage from magic
+)
+
+func newItemDef(name string) *ItemDef {
+	return &ItemDef{
+		name:   name,
+		Counts: true,
+	}
+}
+
+// ===========================================================
+// Load the item definitions file, this runs headless so it
+// can be used by the validate & solve subcommands
+// ===========================================================
+func loadItemDefs() error {
+	data, err := ioutil.ReadFile(itemDefsFile)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("item definitions are not valid JSON: %v", err)
+	}
+
+	itemDefs = map[string]*ItemDef{}
+	for name, rawDef := range raw {
+		def := newItemDef(name)
+		if err := json.Unmarshal(rawDef, def); err != nil {
+			return fmt.Errorf("item '%s' is not valid: %v", name, err)
+		}
+
+		switch def.Effect {
+		case effectHealth, effectMana, effectKey, effectScore:
+		case effectFurniture:
+			// Furniture can't be picked up so it would be impossible to find all the items
+			def.Counts = false
+		case effectWeapon:
+			if def.Weapon == "" {
+				def.Weapon = name
+			}
+		case effectBuff:
+			if def.Buff != buffSpeed && def.Buff != buffDamage {
+				log.Printf("WARNING! Item '%s' has unknown buff '%s'", name, def.Buff)
+			}
+		default:
+			log.Printf("WARNING! Item '%s' has unknown effect '%s'", name, def.Effect)
+		}
+		itemDefs[name] = def
+	}
+
+	log.Printf("Loaded %d item definitions", len(itemDefs))
+	return nil
+}
+
+// Names of all items, sorted so they are listed in a stable order
+func itemNames() []string {
+	names := []string{}
+	for name := range itemDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Furniture items block movement like walls and can't be picked up
+func isFurniture(kind string) bool {
+	def := itemDefs[kind]
+	return def != nil && def.Effect == effectFurniture
+}
+
+// Keys are picked up and held until used on a door
+func isKey(kind string) bool {
+	def := itemDefs[kind]
+	return def != nil && def.Effect == effectKey
+}
+
+// Name the item is held under by the player, if it can be held
+func (d *ItemDef) heldAs() string {
+	switch d.Effect {
+	case effectKey:
+		return d.name
+	case effectWeapon:
+		return d.Weapon
+	}
+	return ""
+}
+
+// ===========================================================
+// Apply the item's effect to the player
+// ===========================================================
+func (d *ItemDef) pickUp(g *Game) {
+	p := &g.player
+	switch d.Effect {
+	case effectHealth:
+		p.health += scaleInt(d.Amount, g.difficultyLevel().itemAmount)
+	case effectMana:
+		p.mana += scaleInt(d.Amount, g.difficultyLevel().itemAmount)
+	case effectKey:
+		p.holding[d.heldAs()]++
+	case effectWeapon:
+		// Switch to new weapons straight away
+		if p.holding[d.heldAs()] == 0 {
+			p.holding[d.heldAs()] = 1
+			p.selectWeapon(g, d.heldAs())
+		}
+	case effectBuff:
+		p.buffs[d.Buff] = d.Duration
+	case effectScore:
+		g.stats.score += d.Amount
+	}
+
+	if d.Sound != "" {
+		playSound(d.Sound, 1, false)
+	}
+}
@@ -2,86 +2,114 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	_ "image/png"
 	"log"
 	"math"
 	"math/rand"
-	"path/filepath"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
-var game *Game
 var Version = "44"
 var debug = false
-var titleLevelIndex = 0
 var titleLevels = []string{}
+var titleCampaigns = []*Campaign{}
+var levelTitles = map[string]string{}
 
 // Global game constants
-const mapSize = 100    // Number of grid cells, maps are assumed to be square
 const cellSize = 32    // Important, how many units is each grid cell in world space - DON'T CHANGE
 const textureSize = 32 // Wall texture size (square)
 
 // Used by raycasting when rendering the view
-const fov = math.Pi / 3.0
+const fov = math.Pi / 3.0          // Horizontal field of view on a 4:3 screen, wider screens see more
 const viewDistance = cellSize * 12 // How far player can see
 var viewRaysRatio = 4.0            // Ratio of rays cast to screen width, higher number = less rays = faster
-const rayStepT = 0.3               // Ray casting step size, larger = less iterations = faster = inaccuracies/gaps
-const epi = 0.01
 
-// These are all constant but dependent on the window size, which can be changed
-var winWidth = 0      // Game window width
-var winHeight = 0     // Game window height
+// These are all constant but dependent on the render resolution, see setResolution
+var winWidth = 0      // Internal framebuffer width
+var winHeight = 0     // Internal framebuffer height
 var winHeightHalf = 0 // Store half the height as we use it a lot
 var viewRays = 0      // Number of rays to cast (see viewRaysRatio)
-var magicWall = 0.0   // Used to scale height of walls
-var magicSprite = 0.0 // Used to scale position of sprites
+var viewFov = fov     // Actual horizontal field of view, adjusted for the aspect ratio
+var projDist = 0.0    // Distance to the projection plane in pixels, used to scale walls & sprites
+var hudScale = 0.0    // Used to scale text and HUD elements
 var hudMargin = 0
 
+// Preset resolutions, any WxH can also be given
+var resolutions = map[string][2]int{
+	"tiny":   {640, 480},
+	"small":  {800, 600},
+	"medium": {1024, 768},
+	"large":  {1280, 960},
+	"larger": {1440, 1080},
+	"super":  {1600, 1200},
+}
+
 // Used for the map overlay view
 var overlayCellSize = cellSize / 2
-var overlayImage = ebiten.NewImage(mapSize*overlayCellSize, mapSize*overlayCellSize)
-var overlayZoom = 5.0
-var overlayShown = false
-
-// Global/precomputed vars for drawing floor and ceiling
-var floorOp *ebiten.DrawImageOptions
-var ceilOp *ebiten.DrawImageOptions
-
-var flashTimer = 0
-var flashColor = []float64{1, 1, 1, 0.8}
-var forceHudUpdate = false
 
 const hudTickInterval = 5 // How many ticks between HUD re-draws
+const messageTicks = 180  // How long HUD messages are shown for
 
 // ===========================================================
 // Load textures & sprites etc
 // ===========================================================
-func init() {
+func loadAssets() {
 	log.Printf("Initializing game, version: %s", Version)
 	// Load all textures and sprites
 	loadImageCache()
 
 	// Find all maps in the maps folder
-	maps, err := filepath.Glob("maps/*.json")
-	if err != nil {
-		log.Fatal(err)
+	for _, name := range listMaps() {
+		titleLevels = append(titleLevels, name)
+
+		// Show the proper level title on the title screen where we can
+		levelTitles[name] = name
+		if mf, err := readMapFile(name); err == nil {
+			levelTitles[name] = mf.Title
+		} else {
+			log.Printf("WARNING! %v", err)
+		}
 	}
-	for _, mapFile := range maps {
-		titleLevels = append(titleLevels, strings.TrimSuffix(filepath.Base(mapFile), ".json"))
+
+	titleCampaigns = loadCampaigns()
+
+	if err := loadDefinitions(); err != nil {
+		log.Fatalln(err)
 	}
 
 	// Load all sounds
 	initSound()
 }
 
+// Load the monster, weapon & item definitions from the data folder
+func loadDefinitions() error {
+	if err := loadMonsterDefs(); err != nil {
+		return err
+	}
+	if err := loadWeaponDefs(); err != nil {
+		return err
+	}
+	return loadItemDefs()
+}
+
 // ===========================================================
 // Entry point
 // ===========================================================
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	// Subcommands run headless, without a window, graphics or sound
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "solve" {
+		os.Exit(runSolve(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:]))
+	}
 
 	var flagRes string
 	var flagRatio int
@@ -89,61 +117,35 @@ func main() {
 	var flagVsync bool
 	var flagDebug bool
 	var flagLevel string
+	var flagDifficulty string
+	var flagSeed int64
+	var flagRecord string
+	var flagPlayDemo string
 	flag.StringVar(&flagLevel, "level", "", "Auto start in this level/map")
-	flag.StringVar(&flagRes, "res", "medium", "Screen resolution: tiny, small, medium, large, larger or super")
+	flag.StringVar(&flagRes, "res", "medium", "Screen resolution: tiny, small, medium, large, larger, super or WxH")
 	flag.IntVar(&flagRatio, "ratio", 4, "Ray rendering ratio as a percentage of screen width")
 	flag.BoolVar(&flagFull, "fullscreen", false, "Fullscreen mode (default false)")
 	flag.BoolVar(&flagVsync, "vsync", false, "Enable vsync (default false)")
 	flag.BoolVar(&flagDebug, "debug", false, "Enable debug mode (default false)")
+	flag.StringVar(&flagDifficulty, "difficulty", "normal", "Difficulty: easy, normal, hard or nightmare")
+	flag.Int64Var(&flagSeed, "seed", 0, "Random seed for the game, levels play out the same with the same seed (default random)")
+	flag.StringVar(&flagRecord, "record", "", "Record a demo of the next level played to this file")
+	flag.StringVar(&flagPlayDemo, "playdemo", "", "Play back a demo file")
 	flag.Parse()
 
 	if flagRatio > 0 {
 		viewRaysRatio = float64(flagRatio)
 	}
 	debug = flagDebug
+	loadAssets()
 
-	switch flagRes {
-	case "tiny":
-		winWidth = 640
-		winHeight = 480
-		magicWall = float64(winHeight) / (float64(cellSize) / 2.6)
-		magicSprite = 4
-	case "small":
-		winWidth = 800
-		winHeight = 600
-		magicWall = float64(winHeight) / (float64(cellSize) / 2.1)
-		magicSprite = 5.2
-	case "medium":
-		winWidth = 1024
-		winHeight = 768
-		magicWall = float64(winHeight) / (float64(cellSize) / 1.666)
-		magicSprite = 6.3
-	case "large":
-		winWidth = 1280
-		winHeight = 960
-		magicWall = float64(winHeight) / (float64(cellSize) / 1.3)
-		magicSprite = 8.3
-	case "larger":
-		winWidth = 1440
-		winHeight = 1080
-		magicWall = float64(winHeight) / (float64(cellSize) / 1.16)
-		magicSprite = 10
-	case "super":
-		winWidth = 1600
-		winHeight = 1200
-		magicWall = float64(winHeight) / (float64(cellSize) / 1.04)
-		magicSprite = 11
-	default:
-		log.Fatalln("Invalid resolution provided, use: tiny, small, medium, large, larger or super")
-	}
-
-	// Set all those magic numbers
-	winHeightHalf = winHeight / 2
-	viewRays = winWidth / int(viewRaysRatio)
-	depthBuffer = make([]float64, viewRays)
-	hudMargin = winHeight / 35
+	width, height, err := parseResolution(flagRes)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	setResolution(width, height)
 
-	// Call this after the magic numbers are set
+	// Call this after the resolution is set
 	initHUD()
 
 	ebiten.SetWindowSize(winWidth, winHeight)
@@ -154,16 +156,39 @@ func main() {
 	} else {
 		ebiten.SetFPSMode(ebiten.FPSModeVsyncOffMaximum)
 	}
+	ebiten.SetMaxTPS(ticksPerSecond)
 	ebiten.SetFullscreen(flagFull)
 
 	log.Printf("Starting game...")
 	log.Printf("Resolution: %dx%d, Ray ratio: %f", winWidth, winHeight, viewRaysRatio)
 
-	game = &Game{
-		state: GameStateTitle,
+	difficulty, err := parseDifficulty(flagDifficulty)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The global generator is only used for things that don't affect the game, e.g. the title screen
+	rand.Seed(time.Now().UnixNano())
+	if flagSeed == 0 {
+		flagSeed = rand.Int63()
 	}
+	log.Printf("Random seed: %d", flagSeed)
+
+	game := newGame(flagSeed, difficulty)
 
-	if flagLevel != "" {
+	if flagRecord != "" && flagPlayDemo != "" {
+		log.Fatalln("Can't record and play a demo at the same time")
+	}
+	game.recordFile = flagRecord
+	if flagPlayDemo != "" {
+		demo, err := loadDemo(flagPlayDemo)
+		if err == nil {
+			err = game.playDemo(demo)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else if flagLevel != "" {
 		game.start(flagLevel)
 	} else {
 		game.returnToTitleScreen()
@@ -175,3 +200,36 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// ===========================================================
+// Parse a resolution, either a preset name or WxH
+// ===========================================================
+func parseResolution(res string) (int, int, error) {
+	if preset, ok := resolutions[res]; ok {
+		return preset[0], preset[1], nil
+	}
+
+	var width, height int
+	if _, err := fmt.Sscanf(res, "%dx%d", &width, &height); err != nil || width < 160 || height < 120 {
+		return 0, 0, fmt.Errorf("invalid resolution '%s', use: tiny, small, medium, large, larger, super or WxH", res)
+	}
+	return width, height, nil
+}
+
+// ===========================================================
+// Set the internal render resolution and everything derived from it
+// ===========================================================
+func setResolution(width, height int) {
+	winWidth = width
+	winHeight = height
+	winHeightHalf = winHeight / 2
+	viewRays = winWidth / int(viewRaysRatio)
+	hudMargin = winHeight / 35
+	hudScale = float64(winHeight) / 120.0
+
+	// The vertical FOV is fixed by fov at 4:3, widescreen gets a wider horizontal FOV
+	aspect := float64(winWidth) / float64(winHeight)
+	vertHalfTan := math.Tan(fov/2) * 3.0 / 4.0
+	viewFov = 2 * math.Atan(vertHalfTan*aspect)
+	projDist = float64(winHeightHalf) / vertHalfTan
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Assets & data are loaded relative to the top of the repo, like the game does
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		log.Fatalln(err)
+	}
+	log.SetOutput(ioutil.Discard)
+	if err := loadDefinitions(); err != nil {
+		log.Fatalln(err)
+	}
+	os.Exit(m.Run())
+}
+
+// Characters used to draw test maps, anything else is an empty cell
+var testMapCells = map[rune]MapFileCell{
+	'#': {Type: "w", Value: "brick_gray_1"},
+	'X': {Type: "w", Value: "brick_gray_1", Extra: []string{"exit"}},
+	'S': {Type: "w", Value: "brick_gray_1", Extra: []string{"secret"}},
+	'D': {Type: "d", Value: "basic"},
+	'R': {Type: "d", Value: "key_red"},
+	'P': {Type: "p", Value: "1"},
+	'k': {Type: "i", Value: "key_red"},
+	'a': {Type: "i", Value: "apple"},
+	'b': {Type: "i", Value: "barrel"},
+	'o': {Type: "m", Value: "orc"},
+}
+
+// ===========================================================
+// Build a small map from rows of text, one character per cell. The player
+// always starts facing right (east), cells can be swapped for anything
+// more complicated by passing them in
+// ===========================================================
+func testMap(rows []string, cells ...*MapFileCell) *MapFile {
+	mapFile := &MapFile{Version: mapFileVersion}
+	mapFile.Title = "test"
+	mapFile.Height = len(rows)
+	for _, row := range rows {
+		if len(row) > mapFile.Width {
+			mapFile.Width = len(row)
+		}
+	}
+
+	mapFile.Cells = make([][]*MapFileCell, mapFile.Width)
+	for x := range mapFile.Cells {
+		mapFile.Cells[x] = make([]*MapFileCell, mapFile.Height)
+	}
+	for y, row := range rows {
+		for x, char := range row {
+			if cell, ok := testMapCells[char]; ok {
+				cell.X, cell.Y = x, y
+				mapFile.Cells[x][y] = &cell
+			}
+		}
+	}
+	for _, cell := range cells {
+		mapFile.Cells[cell.X][cell.Y] = cell
+	}
+	return mapFile
+}
+
+// Save a test map to a temporary file, returns the path to load it from
+func writeTestMap(t *testing.T, mapFile *MapFile) string {
+	t.Helper()
+	data, err := json.Marshal(mapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Start a headless game on a test map
+func testGame(t *testing.T, mapFile *MapFile) *Game {
+	t.Helper()
+	g, err := newHeadlessGame(writeTestMap(t, mapFile), 1, defaultDifficulty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
@@ -2,59 +2,207 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
 )
 
+// Current version of the map file schema, bump this and add a migration step when it changes
+const mapFileVersion = 1
+
 type MapFileCell struct {
-	X     int
-	Y     int
-	Type  string   `json:"t"`
-	Value string   `json:"v"`
-	Extra []string `json:"e"`
+	X       int
+	Y       int
+	Type    string   `json:"t"`
+	Value   string   `json:"v"`
+	Extra   []string `json:"e"`
+	Floor   string   `json:"f,omitempty"` // Optional floor texture, e.g. "walls/slime_6"
+	Ceiling string   `json:"c,omitempty"` // Optional ceiling texture, or "sky"
+
+	Difficulties []string `json:"d,omitempty"` // Only present on these difficulties, or all if empty
+}
+
+// Level metadata held in the map file
+type MapInfo struct {
+	Title       string    `json:"title"`
+	Author      string    `json:"author"`
+	Description string    `json:"description"`
+	Music       string    `json:"music"`     // Sound loop played during the level
+	ParTime     int       `json:"parTime"`   // Par time in seconds, zero for none
+	NextLevel   string    `json:"nextLevel"` // Name of the map that follows this one
+	FogColour   []float64 `json:"fogColour"` // Colour things fade into with distance
+	Script      string    `json:"script"`    // Name of the level's script in maps/scripts, if it has one
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
 }
 
 type MapFile struct {
+	Version int `json:"version"`
+	MapInfo
 	Cells         [][]*MapFileCell `json:"cells"`
 	FloorColour   []float64        `json:"floorColour"`
 	CeilingColour []float64        `json:"ceilingColour"`
+	Triggers      []MapFileTrigger `json:"triggers,omitempty"`
+}
+
+// Names of all the maps in the maps folder
+func listMaps() []string {
+	files, err := filepath.Glob("maps/*.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	names := []string{}
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+	return names
+}
+
+// Maps are named after their file in the maps folder, but a path to any
+// .json file works too, e.g. for maps built by the tests
+func mapPath(name string) string {
+	if strings.HasSuffix(name, ".json") {
+		return name
+	}
+	return "./maps/" + name + ".json"
 }
 
 // ===========================================================
-// Map parser and loader
+// Read a map file, upgrading older versions to the current schema
 // ===========================================================
-func (g *Game) loadMap(name string) error {
-	// Load the map file
-	data, err := ioutil.ReadFile("./maps/" + name + ".json")
+func readMapFile(name string) (*MapFile, error) {
+	data, err := ioutil.ReadFile(mapPath(name))
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	mapFile := &MapFile{}
+	err = json.Unmarshal(data, mapFile)
+	if err != nil {
+		return nil, fmt.Errorf("map '%s' is not valid JSON: %v", name, err)
+	}
+
+	if mapFile.Version > mapFileVersion {
+		return nil, fmt.Errorf("map '%s' has version %d, but this version of the game only supports up to version %d", name, mapFile.Version, mapFileVersion)
+	}
+
+	// Version 0 is the original unversioned format, with just cells and colours
+	if mapFile.Version == 0 {
+		mapFile.Title = name
+		mapFile.Music = "loop_ambient_1"
+		mapFile.Width = len(mapFile.Cells)
+		for _, cellRow := range mapFile.Cells {
+			if len(cellRow) > mapFile.Height {
+				mapFile.Height = len(cellRow)
+			}
+		}
+		mapFile.Version = 1
+	}
+
+	if mapFile.Title == "" {
+		mapFile.Title = name
+	}
+	if mapFile.FloorColour == nil {
+		mapFile.FloorColour = []float64{1, 1, 1}
+	}
+	if mapFile.CeilingColour == nil {
+		mapFile.CeilingColour = []float64{1, 1, 1}
+	}
+	if mapFile.FogColour == nil {
+		mapFile.FogColour = []float64{0, 0, 0}
 	}
 
-	// Raw map holds the unmarshalled map data from JSON
-	//mapRaw := make([][]*MapFileCell, 0)
-	mapFile := MapFile{}
-	err = json.Unmarshal([]byte(data), &mapFile)
+	if mapFile.Width <= 0 || mapFile.Height <= 0 {
+		return nil, fmt.Errorf("map '%s' has an invalid size of %dx%d", name, mapFile.Width, mapFile.Height)
+	}
+
+	colours := map[string][]float64{"floorColour": mapFile.FloorColour, "ceilingColour": mapFile.CeilingColour, "fogColour": mapFile.FogColour}
+	for field, colour := range colours {
+		if len(colour) != 3 {
+			return nil, fmt.Errorf("map '%s' has an invalid %s, it must have three values", name, field)
+		}
+	}
+
+	return mapFile, nil
+}
+
+// ===========================================================
+// Map parser and loader
+// ===========================================================
+func (g *Game) loadMap(name string) error {
+	// Load the map file
+	mapFile, err := readMapFile(name)
 	if err != nil {
 		return err
 	}
+	g.mapInfo = mapFile.MapInfo
 
 	// This is the real map data used by the game
-	g.mapdata = make([][]*Wall, mapSize)
+	g.mapWidth = mapFile.Width
+	g.mapHeight = mapFile.Height
+	g.mapdata = make([][]*Wall, g.mapWidth)
 	for i := range g.mapdata {
-		g.mapdata[i] = make([]*Wall, mapSize)
+		g.mapdata[i] = make([]*Wall, g.mapHeight)
 	}
 
-	ceilOp.ColorM.Scale(mapFile.CeilingColour[0], mapFile.CeilingColour[1], mapFile.CeilingColour[2], 1)
-	floorOp.ColorM.Scale(mapFile.FloorColour[0], mapFile.FloorColour[1], mapFile.FloorColour[2], 1)
+	// Per cell floor & ceiling textures
+	g.surfaces = make([][]Surface, g.mapWidth)
+	for i := range g.surfaces {
+		g.surfaces[i] = make([]Surface, g.mapHeight)
+	}
+	g.hasSurfaces = false
+
+	if !g.headless {
+		g.overlayImage = ebiten.NewImage(g.mapWidth*overlayCellSize, g.mapHeight*overlayCellSize)
+	}
+
+	g.ceilOp.ColorM.Scale(mapFile.CeilingColour[0], mapFile.CeilingColour[1], mapFile.CeilingColour[2], 1)
+	g.floorOp.ColorM.Scale(mapFile.FloorColour[0], mapFile.FloorColour[1], mapFile.FloorColour[2], 1)
 
 	// Parse the raw map into the mapdata
 	for _, cellRow := range mapFile.Cells {
 		for _, cell := range cellRow {
+			if cell == nil {
+				continue
+			}
+			if !g.inBounds(cell.X, cell.Y) {
+				return fmt.Errorf("map '%s' has cell %d,%d outside of its %dx%d size", name, cell.X, cell.Y, g.mapWidth, g.mapHeight)
+			}
 			g.mapdata[cell.X][cell.Y] = nil
 
+			// Floor & ceiling textures
+			if cell.Floor != "" {
+				if g.surfaces[cell.X][cell.Y].floor, err = loadTexture(cell.Floor); err != nil {
+					return err
+				}
+				g.hasSurfaces = true
+			}
+			if cell.Ceiling == skyCeiling {
+				g.surfaces[cell.X][cell.Y].sky = true
+				g.hasSurfaces = true
+			} else if cell.Ceiling != "" {
+				if g.surfaces[cell.X][cell.Y].ceiling, err = loadTexture(cell.Ceiling); err != nil {
+					return err
+				}
+				g.hasSurfaces = true
+			}
+
+			// Anything in the cell might only be there on some difficulties
+			if !cell.presentOn(g.difficultyLevel().name) {
+				continue
+			}
+
 			// Walls and decorations, switches etc
 			if cell.Type == "w" {
+				if !imageExists("walls/" + cell.Value) {
+					return fmt.Errorf("map '%s' has unknown wall texture '%s' at %d,%d", name, cell.Value, cell.X, cell.Y)
+				}
 				g.mapdata[cell.X][cell.Y] = newWall(cell.X, cell.Y, cell.Value)
 				if len(cell.Extra) > 0 {
 					if cell.Extra[0] == "deco" {
@@ -62,14 +210,33 @@ func (g *Game) loadMap(name string) error {
 					}
 					if cell.Extra[0] == "secret" {
 						g.mapdata[cell.X][cell.Y] = newSecretWall(cell.X, cell.Y, cell.Value)
+						g.stats.secretsTotal++
 					}
 					if cell.Extra[0] == "exit" {
 						g.mapdata[cell.X][cell.Y] = newExitWall(cell.X, cell.Y, cell.Value)
 					}
 					if cell.Extra[0] == "switch" {
-						targetX, _ := strconv.Atoi(cell.Extra[1])
-						targetY, _ := strconv.Atoi(cell.Extra[2])
-						g.mapdata[cell.X][cell.Y] = newSwitchWall(cell.X, cell.Y, cell.Value, targetX, targetY)
+						sw, err := parseSwitch(cell.Extra)
+						if err != nil {
+							return fmt.Errorf("map '%s' has a bad switch at %d,%d: %v", name, cell.X, cell.Y, err)
+						}
+						for _, target := range sw.cells() {
+							if !g.inBounds(target[0], target[1]) {
+								return fmt.Errorf("map '%s' has a switch at %d,%d targeting %d,%d which is outside the map", name, cell.X, cell.Y, target[0], target[1])
+							}
+						}
+						g.mapdata[cell.X][cell.Y] = newSwitchWall(cell.X, cell.Y, cell.Value, sw)
+						g.switches = append(g.switches, sw)
+					}
+					if cell.Extra[0] == "teleport" {
+						t, err := parseTeleporter(cell.Extra[1:], false)
+						if err != nil {
+							return fmt.Errorf("map '%s' has a bad teleporter wall at %d,%d: %v", name, cell.X, cell.Y, err)
+						}
+						if !g.inBounds(t.dest[0], t.dest[1]) {
+							return fmt.Errorf("map '%s' has a teleporter at %d,%d going to %d,%d which is outside the map", name, cell.X, cell.Y, t.dest[0], t.dest[1])
+						}
+						g.mapdata[cell.X][cell.Y] = newTeleporterWall(cell.X, cell.Y, cell.Value, t)
 					}
 					g.mapdata[cell.X][cell.Y].metadata = append(g.mapdata[cell.X][cell.Y].metadata, cell.Extra...)
 				}
@@ -77,12 +244,46 @@ func (g *Game) loadMap(name string) error {
 
 			// Doors
 			if cell.Type == "d" {
-				g.mapdata[cell.X][cell.Y] = newDoor(cell.X, cell.Y, cell.Value)
+				wall := newDoor(cell.X, cell.Y, cell.Value)
+				oneWay, stayOpen, err := parseDoorFlags(cell.Extra)
+				if err != nil {
+					return fmt.Errorf("map '%s' has a door at %d,%d with bad flags: %v", name, cell.X, cell.Y, err)
+				}
+				wall.door.oneWay = oneWay
+				wall.door.stayOpen = stayOpen
+				g.mapdata[cell.X][cell.Y] = wall
+				g.doors = append(g.doors, wall.door)
 			}
 
 			// Monsters
 			if cell.Type == "m" {
-				g.addMonster(cell.Value, cell.X, cell.Y)
+				mon := g.addMonster(cell.Value, cell.X, cell.Y)
+				if mon != nil && len(cell.Extra) > 0 {
+					patrol, ambush, err := parseMonsterOrders(cell.Extra)
+					if err != nil {
+						return fmt.Errorf("map '%s' has a monster at %d,%d with bad orders: %v", name, cell.X, cell.Y, err)
+					}
+					if len(patrol) > 0 {
+						mon.patrol = append([][2]int{{cell.X, cell.Y}}, patrol...)
+					}
+					if ambush {
+						// No timed state change either, or it would wake up straight away
+						mon.state = MonsterStateAmbush
+						mon.stateTicker = 0
+					}
+				}
+			}
+
+			// Teleporter pads, the value is where they go
+			if cell.Type == "t" {
+				t, err := parseTeleporter(append([]string{cell.Value}, cell.Extra...), true)
+				if err != nil {
+					return fmt.Errorf("map '%s' has a bad teleporter at %d,%d: %v", name, cell.X, cell.Y, err)
+				}
+				if !g.inBounds(t.dest[0], t.dest[1]) {
+					return fmt.Errorf("map '%s' has a teleporter at %d,%d going to %d,%d which is outside the map", name, cell.X, cell.Y, t.dest[0], t.dest[1])
+				}
+				g.addTeleporterPad(cell.X, cell.Y, t)
 			}
 
 			// Items
@@ -100,6 +301,95 @@ func (g *Game) loadMap(name string) error {
 		}
 	}
 
+	// Needs all the walls in place first
+	for _, door := range g.doors {
+		door.setOrientation(g)
+	}
+
+	if mapFile.Script != "" {
+		if g.script, err = loadScript(mapFile.Script); err != nil {
+			return fmt.Errorf("map '%s' %v", name, err)
+		}
+		if err = g.script.init(g); err != nil {
+			return fmt.Errorf("map '%s' %v", name, err)
+		}
+	}
+
+	for i, mt := range mapFile.Triggers {
+		t, err := parseTrigger(mt)
+		if err != nil {
+			return fmt.Errorf("map '%s' has a bad trigger %d: %v", name, i+1, err)
+		}
+		for _, cell := range t.cells() {
+			if !g.inBounds(cell[0], cell[1]) {
+				return fmt.Errorf("map '%s' has trigger %d referring to %d,%d which is outside the map", name, i+1, cell[0], cell[1])
+			}
+		}
+		for _, fn := range t.scriptCalls() {
+			if g.script == nil || g.script.funcs[fn] == nil {
+				return fmt.Errorf("map '%s' has trigger %d calling '%s' which isn't in the level's script", name, i+1, fn)
+			}
+		}
+		// Starting inside an area doesn't count as entering it
+		t.inside = t.contains(g.player.cellX, g.player.cellY)
+		g.triggers = append(g.triggers, t)
+	}
+	g.triggerCell = [2]int{g.player.cellX, g.player.cellY}
+
 	g.mapName = name
 	return nil
 }
+
+// Monster orders are either ["ambush"] or ["patrol", x1, y1, x2, y2, ...]
+func parseMonsterOrders(extra []string) ([][2]int, bool, error) {
+	switch extra[0] {
+	case "ambush":
+		return nil, true, nil
+	case "patrol":
+		if len(extra) < 3 || len(extra)%2 != 1 {
+			return nil, false, fmt.Errorf("patrol needs a list of x & y cells")
+		}
+		patrol := [][2]int{}
+		for i := 1; i < len(extra); i += 2 {
+			x, errX := strconv.Atoi(extra[i])
+			y, errY := strconv.Atoi(extra[i+1])
+			if errX != nil || errY != nil {
+				return nil, false, fmt.Errorf("patrol cell '%s,%s' is not a number", extra[i], extra[i+1])
+			}
+			patrol = append(patrol, [2]int{x, y})
+		}
+		return patrol, false, nil
+	}
+	return nil, false, fmt.Errorf("unknown orders '%s'", extra[0])
+}
+
+// Arrange the cells into a 2D grid indexed by x & y, cells outside the map are dropped
+func (m *MapFile) grid() [][]*MapFileCell {
+	grid := make([][]*MapFileCell, m.Width)
+	for x := range grid {
+		grid[x] = make([]*MapFileCell, m.Height)
+	}
+
+	for _, cellRow := range m.Cells {
+		for _, cell := range cellRow {
+			if cell == nil || cell.X < 0 || cell.Y < 0 || cell.X >= m.Width || cell.Y >= m.Height {
+				continue
+			}
+			grid[cell.X][cell.Y] = cell
+		}
+	}
+	return grid
+}
+
+// The grid as it is played on a difficulty, cells limited to other difficulties are left empty
+func (m *MapFile) gridOn(difficulty string) [][]*MapFileCell {
+	grid := m.grid()
+	for x := range grid {
+		for y, cell := range grid[x] {
+			if cell != nil && !cell.presentOn(difficulty) {
+				grid[x][y] = nil
+			}
+		}
+	}
+	return grid
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
+	"log"
 	"math"
-	"math/rand"
-	"time"
 )
 
 type MonsterState int
@@ -15,134 +14,104 @@ const (
 	MonsterStateRecoil
 	MonsterStateWander
 	MonsterStateDoNothing
+	MonsterStatePatrol // Walking a route set in the map
+	MonsterStateSearch // Looking around where the player was last seen
+	MonsterStateFlee   // Running away at low health
+	MonsterStateAmbush // Dormant until the player gets close or it's hurt
+)
+
+const (
+	searchTicks = 360
+	fleeTicks   = 180
+	ambushRange = cellSize * 2.5
 )
 
 type Monster struct {
-	id               uint64
-	sprite           *Sprite
-	health           int
-	meleeDamage      int
-	projectileDamage int
-	state            MonsterState
-	stateTicker      int
-	baseSpeed        float64
-	canShoot         bool
-	projectileKind   string
-	projectileProb   float64
-	projectileSpeed  float64
-	seenPlayer       bool
+	id          uint64
+	def         *MonsterDef
+	sprite      *Sprite
+	health      int
+	state       MonsterState
+	stateTicker int
+	baseSpeed   float64
+	frame       int
+	seenPlayer  bool
+	lastSeenX   float64 // Where the player was last seen, monsters will chase to here
+	lastSeenY   float64
+	path        [][2]int // Cells to walk through to reach the player
+	pathTarget  [2]int   // Cell the path leads to
+	onPath      bool     // Following the path this tick
+	patrol      [][2]int // Cells to walk between when there's nothing else to do
+	patrolIndex int
+	goal        [2]int // Cell being searched or fled to
 }
 
-func (g *Game) addMonster(kind string, x, y int) {
+func (g *Game) addMonster(kind string, x, y int) *Monster {
 	const monsterSize = float64(cellSize) / 4
 	cx := float64(x)*cellSize + cellSize/2
 	cy := float64(y)*cellSize + cellSize/2
-	angle := rand.Float64() * 2 * math.Pi
-
-	// We hope that 64 bit ints are unique enough
-	id := rand.Uint64()
-	mon := &Monster{
-		id:               id,
-		sprite:           g.addSprite("monsters/"+kind, cx, cy, angle, 1, monsterSize),
-		health:           10,
-		meleeDamage:      10,
-		projectileDamage: 10,
-		state:            MonsterStateIdle,
-		baseSpeed:        1,
-		canShoot:         false,
-		projectileKind:   "slime",
-		stateTicker:      1,
-		projectileProb:   0.0,
-		projectileSpeed:  (float64(cellSize) / 9.0),
-	}
-
-	if kind == "skeleton" {
-		mon.health = 35
-		mon.canShoot = true
-		mon.baseSpeed = rand.Float64()*0.5 + 0.5
-		mon.projectileDamage = 6
-		mon.projectileKind = "bone"
-		mon.projectileProb = 60.0
-	}
-
-	if kind == "thing" {
-		mon.health = 100
-		mon.canShoot = true
-		mon.baseSpeed = rand.Float64()*0.5 + 0.5
-		mon.projectileDamage = 12
-		mon.projectileKind = "slime"
-		mon.projectileProb = 50.0
-		mon.projectileSpeed = mon.projectileSpeed * 0.8
-	}
-
-	if kind == "ghoul" {
-		mon.health = 75
-		mon.meleeDamage = 30
-		mon.baseSpeed = rand.Float64()*0.6 + 0.3
-	}
+	angle := g.rng.Float64() * 2 * math.Pi
 
-	if kind == "orc" {
-		mon.health = 50
-		mon.meleeDamage = 10
-		mon.baseSpeed = rand.Float64()*0.8 + 1
+	def := monsterDefs[kind]
+	if def == nil {
+		log.Printf("ERROR! Unknown monster '%s' at %d,%d", kind, x, y)
+		return nil
 	}
 
-	if kind == "wiz" {
-		mon.health = 50
-		mon.meleeDamage = 10
-		mon.baseSpeed = rand.Float64()*0.4 + 0.3
-		mon.canShoot = true
-		mon.projectileDamage = 18
-		mon.projectileKind = "fireball"
-		mon.projectileProb = 45.0
-		mon.projectileSpeed = mon.projectileSpeed * 0.6
+	id := g.newID()
+	mon := &Monster{
+		id:          id,
+		def:         def,
+		sprite:      g.addSprite("monsters/"+def.Frames[0], cx, cy, angle, 1, monsterSize),
+		health:      scaleInt(def.Health, g.difficultyLevel().monsterHealth),
+		state:       MonsterStateIdle,
+		baseSpeed:   (def.Speed[0] + g.rng.Float64()*(def.Speed[1]-def.Speed[0])) * g.difficultyLevel().monsterSpeed,
+		stateTicker: 1,
 	}
-
-	if kind == "spectre" {
-		mon.health = 120
-		mon.meleeDamage = 15
-		mon.baseSpeed = rand.Float64()*0.6 + 1
-		mon.sprite.alpha = 0.4
+	if mon.sprite == nil {
+		return nil
 	}
+	mon.sprite.alpha = def.Alpha
 
 	mon.sprite.speed = mon.baseSpeed
 	g.monsters[mon.id] = mon
 	g.stats.monsters++
+	return mon
 }
 
-func (m *Monster) checkWallCollision(x, y float64) (*Wall, float64, float64) {
+func (m *Monster) canShoot() bool {
+	return m.def.Projectile != nil && m.def.hasBehaviour(behaviourRanged)
+}
+
+func (m *Monster) checkWallCollision(g *Game, x, y float64) (*Wall, float64, float64) {
 	size := m.sprite.size
-	if wall := game.getWallAt(x+size, y); wall != nil {
+	if wall := g.getWallAt(x+size, y); wall != nil {
 		return wall, x + size, y
 	}
-	if wall := game.getWallAt(x-size, y); wall != nil {
+	if wall := g.getWallAt(x-size, y); wall != nil {
 		return wall, x - size, y
 	}
-	if wall := game.getWallAt(x, y+size); wall != nil {
+	if wall := g.getWallAt(x, y+size); wall != nil {
 		return wall, x, y + size
 	}
-	if wall := game.getWallAt(x, y-size); wall != nil {
+	if wall := g.getWallAt(x, y-size); wall != nil {
 		return wall, x, y - size
 	}
 	return nil, 0, 0
 }
 
 func (g *Game) updateMonsters() {
-	for _, mon := range g.monsters {
+	for _, mon := range g.monsterList() {
 		sprite := mon.sprite
 		playerDist := sprite.getDistanceToPlayer(g.player)
-		if playerDist > viewDistance {
-			continue
-		}
-
-		// Animations!
-		if g.ticks%20 == 0 {
-			if imageCache[sprite.kind+"-1"] != nil {
-				if sprite.image == imageCache[sprite.kind+"-1"] {
-					mon.sprite.image = imageCache[sprite.kind]
-				} else {
-					mon.sprite.image = imageCache[sprite.kind+"-1"]
-				}
+		inView := playerDist <= viewDistance
+
+		// Animations! Only for monsters near enough to be seen, but the rest
+		// still patrol, search and hear noises
+		if inView && g.ticks%mon.def.FrameTicks == 0 && len(mon.def.Frames) > 1 {
+			mon.frame = (mon.frame + 1) % len(mon.def.Frames)
+			if img := imageCache["monsters/"+mon.def.Frames[mon.frame]]; img != nil {
+				sprite.image = img
 			}
 		}
 
@@ -154,7 +123,7 @@ func (g *Game) updateMonsters() {
 				mon.state = MonsterStateIdle
 				mon.stateTicker = 0
 				// random angle
-				sprite.angle = rand.Float64() * 2 * math.Pi
+				sprite.angle = g.rng.Float64() * 2 * math.Pi
 			}
 		}
 
@@ -163,46 +132,116 @@ func (g *Game) updateMonsters() {
 			// Do nothing
 		}
 
+		mon.onPath = false
+		see, angleToPlayer := false, 0.0
+		lurking := mon.state == MonsterStateAmbush && playerDist >= ambushRange
+		if inView && mon.state != MonsterStateDoNothing && mon.state != MonsterStateRecoil && !lurking {
+			see, angleToPlayer = mon.checkLosToPlayer(g)
+			if see {
+				mon.lastSeenX, mon.lastSeenY = g.player.x, g.player.y
+			}
+		}
+
 		if mon.state == MonsterStateIdle {
-			if see, _ := mon.checkLosToPlayer(g.player); see {
-				if mon.canShoot {
-					mon.state = MonsterStateAttack
-				} else {
-					mon.state = MonsterStateMelee
+			if see {
+				mon.engage(g)
+			} else if len(mon.patrol) > 0 {
+				mon.state = MonsterStatePatrol
+			}
+		}
+
+		if mon.state == MonsterStatePatrol {
+			if see {
+				mon.engage(g)
+			} else {
+				sprite.speed = mon.baseSpeed * 0.6
+				target := mon.patrol[mon.patrolIndex]
+				if !mon.followPath(g, float64(target[0])*cellSize+cellSize/2, float64(target[1])*cellSize+cellSize/2) {
+					mon.patrolIndex = (mon.patrolIndex + 1) % len(mon.patrol)
+				}
+			}
+		}
+
+		if mon.state == MonsterStateAmbush {
+			sprite.speed = 0
+			if see {
+				mon.engage(g)
+			}
+		}
+
+		if mon.state == MonsterStateSearch {
+			if see {
+				mon.engage(g)
+			} else {
+				sprite.speed = mon.baseSpeed * 0.8
+				if !mon.followPath(g, float64(mon.goal[0])*cellSize+cellSize/2, float64(mon.goal[1])*cellSize+cellSize/2) {
+					// Look somewhere else near where the player was last seen
+					if goal, ok := g.randomCellNear(int(mon.lastSeenX/cellSize), int(mon.lastSeenY/cellSize), 3); ok {
+						mon.goal = goal
+					}
 				}
 			}
 		}
 
+		if mon.state == MonsterStateFlee {
+			sprite.speed = mon.baseSpeed * 1.2
+			if !mon.followPath(g, float64(mon.goal[0])*cellSize+cellSize/2, float64(mon.goal[1])*cellSize+cellSize/2) {
+				mon.goal = g.fleeCell(sprite.x, sprite.y)
+			}
+		}
+
 		if mon.state == MonsterStateRecoil {
 			sprite.speed = -(mon.baseSpeed * 1.2)
 		}
 
 		if mon.state == MonsterStateAttack {
-			var angleToPlayer float64
-			var see bool
-			if see, angleToPlayer = mon.checkLosToPlayer(g.player); !see {
-				mon.seenPlayer = false
-				mon.state = MonsterStateIdle
+			if !see {
+				mon.search()
+				continue
+			}
+			if mon.lowHealth(g) {
+				mon.flee(g)
 				continue
 			}
-			if rand.Float64()*10000.0 <= mon.projectileProb {
+
+			// Casters try to keep their distance, but stay close enough to hit
+			keepDistance := mon.def.KeepDistance * cellSize
+			sprite.angle = angleToPlayer
+			switch {
+			case playerDist < keepDistance:
+				sprite.speed = -mon.baseSpeed
+			case playerDist > keepDistance*2:
+				sprite.speed = mon.baseSpeed
+			default:
+				sprite.speed = 0
+			}
+
+			proj := mon.def.Projectile
+			difficulty := g.difficultyLevel()
+			if g.rng.Float64()*10000.0 <= proj.Chance*difficulty.projectileChance {
 				sx := sprite.x + math.Cos(angleToPlayer)*32
 				sy := sprite.y + math.Sin(angleToPlayer)*32
-				game.addProjectile(mon.projectileKind, sx, sy, angleToPlayer, mon.projectileSpeed, mon.projectileDamage, 1)
-				playSound("whoosh", 1, false)
+				g.addProjectile(proj.Kind, sx, sy, angleToPlayer, projectileSpeed*proj.Speed, scaleInt(proj.Damage, difficulty.monsterDamage), 1)
+				playSound(mon.def.Sounds.Shoot, 1, false)
 			}
 		}
 
 		if mon.state == MonsterStateMelee {
-			var angleToPlayer float64
-			var see bool
-			if see, angleToPlayer = mon.checkLosToPlayer(g.player); !see {
-				mon.seenPlayer = false
-				mon.state = MonsterStateIdle
+			if see && mon.lowHealth(g) {
+				mon.flee(g)
 				continue
 			}
 			sprite.speed = mon.baseSpeed
-			sprite.angle = angleToPlayer
+
+			// Route around walls to where the player is, or was last seen
+			if !mon.followPath(g, mon.lastSeenX, mon.lastSeenY) {
+				if !see {
+					// Got to where the player was last seen, but they've gone
+					mon.search()
+					continue
+				}
+				sprite.angle = angleToPlayer
+			}
 		}
 
 		// Move the monster
@@ -210,14 +249,21 @@ func (g *Game) updateMonsters() {
 		newY := sprite.y + math.Sin(sprite.angle)*sprite.speed
 
 		// Check if it hits a wall
-		if wall, _, _ := mon.checkWallCollision(newX, newY); wall == nil {
+		if wall, _, _ := mon.checkWallCollision(g, newX, newY); wall == nil {
 			// Check if they move into the player
 			if playerDist < (g.player.size*3+sprite.size) && mon.state != MonsterStateRecoil {
-				playSound("monster_attack", 1, false)
-				g.player.damage(mon.meleeDamage)
+				playSound(mon.def.Sounds.Attack, 1, false)
+				g.player.damage(g, scaleInt(mon.def.MeleeDamage, g.difficultyLevel().monsterDamage))
 				mon.state = MonsterStateRecoil
 				mon.stateTicker = 45
 			}
+		} else if mon.onPath {
+			// Clipped a corner following a path, step back towards the middle of the cell
+			cx := math.Floor(sprite.x/cellSize)*cellSize + cellSize/2
+			cy := math.Floor(sprite.y/cellSize)*cellSize + cellSize/2
+			angle := math.Atan2(cy-sprite.y, cx-sprite.x)
+			newX = sprite.x + math.Cos(angle)*sprite.speed
+			newY = sprite.y + math.Sin(angle)*sprite.speed
 		} else {
 			// This weird code, stops monsters getting stuck on walls when walking towards the player
 			sprite.speed = mon.baseSpeed
@@ -253,16 +299,128 @@ func (g *Game) updateMonsters() {
 			newY = sprite.y
 			// newX = sprite.x + math.Cos(oldAngle)*-sprite.speed
 			// newY = sprite.y + math.Sin(oldAngle)*-sprite.speed
-			//logMessage(fmt.Sprintf("Monster wall turn %s: %f", mon.sprite.kind, mon.sprite.angle))
+			//g.logMessage(fmt.Sprintf("Monster wall turn %s: %f", mon.sprite.kind, mon.sprite.angle))
 		}
+		oldX, oldY := sprite.x, sprite.y
 		sprite.x = newX
 		sprite.y = newY
+		if g.teleportSprite(sprite, oldX, oldY, true) {
+			// The path was from the other side of the pad, so find a new one straight away
+			mon.path = nil
+			mon.pathTarget = [2]int{-1, -1}
+			mon.onPath = false
+		}
+	}
+}
+
+// ===========================================================
+// Steer the monster along a path towards a point, returns false when
+// it is already in the same cell or there is no way to get there
+// ===========================================================
+func (m *Monster) followPath(g *Game, x, y float64) bool {
+	sprite := m.sprite
+	target := [2]int{int(x / cellSize), int(y / cellSize)}
+	cellX, cellY := int(sprite.x/cellSize), int(sprite.y/cellSize)
+	if target == [2]int{cellX, cellY} {
+		m.path = nil
+		return false
+	}
+
+	// Only find a new path when the target moves, and retry now & then if there wasn't one
+	if target != m.pathTarget || (len(m.path) == 0 && g.ticks%30 == 0) {
+		m.path = g.findPath(cellX, cellY, target[0], target[1])
+		m.pathTarget = target
 	}
+
+	// Waypoints are the centers of cells, skip any we've arrived at
+	for len(m.path) > 0 {
+		wx := float64(m.path[0][0])*cellSize + cellSize/2
+		wy := float64(m.path[0][1])*cellSize + cellSize/2
+		if math.Hypot(wx-sprite.x, wy-sprite.y) > cellSize/4 {
+			sprite.angle = math.Atan2(wy-sprite.y, wx-sprite.x)
+			m.onPath = true
+			return true
+		}
+		m.path = m.path[1:]
+	}
+
+	return false
 }
 
-func (m *Monster) checkLosToPlayer(p Player) (canSee bool, angle float64) {
-	playerDist := m.sprite.getDistanceToPlayer(game.player)
-	wall, dist, a := fireRayAt(m.sprite.x, m.sprite.y, p.x, p.y, playerDist)
+// Go after the player, or run away if too badly hurt
+func (m *Monster) engage(g *Game) {
+	m.stateTicker = 0
+	switch {
+	case m.lowHealth(g):
+		m.flee(g)
+	case m.canShoot():
+		m.state = MonsterStateAttack
+	default:
+		m.state = MonsterStateMelee
+	}
+}
+
+// Lost sight of the player, so look around where they were last seen
+func (m *Monster) search() {
+	m.seenPlayer = false
+	m.state = MonsterStateSearch
+	m.stateTicker = searchTicks
+	m.goal = [2]int{int(m.lastSeenX / cellSize), int(m.lastSeenY / cellSize)}
+}
+
+func (m *Monster) flee(g *Game) {
+	m.state = MonsterStateFlee
+	m.stateTicker = fleeTicks
+	m.goal = g.fleeCell(m.sprite.x, m.sprite.y)
+}
+
+// Something got the monster's attention, go and see what it was
+func (m *Monster) alert(x, y float64) {
+	if m.state == MonsterStateFlee || m.state == MonsterStateMelee || m.state == MonsterStateAttack {
+		return
+	}
+	m.lastSeenX, m.lastSeenY = x, y
+	m.search()
+}
+
+func (m *Monster) lowHealth(g *Game) bool {
+	return float64(m.health) < float64(m.def.Health)*g.difficultyLevel().monsterHealth*m.def.FleeHealth
+}
+
+// Pick a random open cell within range of another, used when searching
+func (g *Game) randomCellNear(cellX, cellY, dist int) ([2]int, bool) {
+	for tries := 0; tries < 10; tries++ {
+		x := cellX + g.rng.Intn(dist*2+1) - dist
+		y := cellY + g.rng.Intn(dist*2+1) - dist
+		if g.isWalkable(x, y) {
+			return [2]int{x, y}, true
+		}
+	}
+	return [2]int{cellX, cellY}, false
+}
+
+// Pick an open cell near the monster which is as far from the player as we can find
+func (g *Game) fleeCell(x, y float64) [2]int {
+	best := [2]int{int(x / cellSize), int(y / cellSize)}
+	bestDist := 0.0
+	for tries := 0; tries < 10; tries++ {
+		cell, ok := g.randomCellNear(int(x/cellSize), int(y/cellSize), 6)
+		if !ok {
+			continue
+		}
+		dx := float64(cell[0])*cellSize + cellSize/2 - g.player.x
+		dy := float64(cell[1])*cellSize + cellSize/2 - g.player.y
+		if dist := math.Hypot(dx, dy); dist > bestDist {
+			best, bestDist = cell, dist
+		}
+	}
+	return best
+}
+
+func (m *Monster) checkLosToPlayer(g *Game) (canSee bool, angle float64) {
+	p := g.player
+	playerDist := m.sprite.getDistanceToPlayer(p)
+	wall, dist, a := g.fireRayAt(m.sprite.x, m.sprite.y, p.x, p.y, playerDist)
 
 	if wall == nil {
 		if dist >= viewDistance {
@@ -270,7 +428,7 @@ func (m *Monster) checkLosToPlayer(p Player) (canSee bool, angle float64) {
 		}
 		if dist <= playerDist {
 			if !m.seenPlayer {
-				playSound("monster_grunt", 1, false)
+				playSound(m.def.Sounds.See, 1, false)
 			}
 			m.seenPlayer = true
 			return true, a
@@ -287,13 +445,29 @@ func (g *Game) removeMonster(m *Monster) {
 	g.stats.kills++
 }
 
-func (m *Monster) kill() {
-	s := game.addSprite(m.sprite.kind+"-dead", m.sprite.x, m.sprite.y, 0, 0, 0)
-	s.alpha = m.sprite.alpha
-	game.removeMonster(m)
-	time.AfterFunc(time.Millisecond*300, func() {
-		game.removeSprite(s)
-	})
+func (m *Monster) kill(g *Game) {
+	// Shown briefly where it died, if the image can be found
+	s := g.addSprite("monsters/"+m.def.Dead, m.sprite.x, m.sprite.y, 0, 0, 0)
+	if s != nil {
+		s.alpha = m.sprite.alpha
+	}
+
+	// Maybe leave something behind, only one item can be dropped
+	cellX, cellY := int(m.sprite.x/cellSize), int(m.sprite.y/cellSize)
+	for _, drop := range m.def.Drops {
+		if g.rng.Float64() < drop.Chance {
+			g.addDrop(drop.Item, cellX, cellY)
+			break
+		}
+	}
+
+	g.removeMonster(m)
+	if s != nil {
+		g.after(ticksPerSecond*3/10, func() {
+			g.removeSprite(s)
+		})
+	}
+	g.runScript("onKill", m.def.name, float64(cellX), float64(cellY))
 }
 
 func (s *Sprite) getDistanceToPlayer(p Player) float64 {
@@ -302,12 +476,14 @@ func (s *Sprite) getDistanceToPlayer(p Player) float64 {
 	return math.Sqrt(dx*dx + dy*dy)
 }
 
-func (m *Monster) damage(d int) {
+func (m *Monster) damage(g *Game, d int) {
 	m.health -= d
 	if m.health <= 0 {
-		playSound("monster_death", 1.0, false)
-		m.kill()
+		playSound(m.def.Sounds.Death, 1.0, false)
+		m.kill(g)
 	} else {
-		playSound("monster_hit", 1.0, false)
+		playSound(m.def.Sounds.Hit, 1.0, false)
+		// Getting hurt wakes monsters up, and sends them looking for whoever did it
+		m.alert(g.player.x, g.player.y)
 	}
 }
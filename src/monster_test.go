@@ -0,0 +1,122 @@
+package main
+
+import "testing"
+
+func TestAmbushWaitsForPlayer(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"##########",
+		"#P......o#",
+		"##########",
+	}, &MapFileCell{X: 8, Y: 1, Type: "m", Value: "orc", Extra: []string{"ambush"}}))
+	mon := g.monsterList()[0]
+	x, y := mon.sprite.x, mon.sprite.y
+
+	g.run(ticksPerSecond*3, 0)
+	if mon.state != MonsterStateAmbush {
+		t.Fatalf("ambusher should still be waiting, state is %v", mon.state)
+	}
+	if mon.sprite.x != x || mon.sprite.y != y {
+		t.Errorf("ambusher moved from %.1f,%.1f to %.1f,%.1f", x, y, mon.sprite.x, mon.sprite.y)
+	}
+
+	// Springs the ambush once the player gets close
+	g.player.moveToCell(6, 1)
+	g.run(10, 0)
+	if mon.state == MonsterStateAmbush {
+		t.Error("ambusher should have attacked the player when they got close")
+	}
+}
+
+func TestDistantMonstersKeepMoving(t *testing.T) {
+	rows := []string{
+		"######################",
+		"#P...................#",
+		"######################",
+	}
+	patrol := &MapFileCell{X: 19, Y: 1, Type: "m", Value: "orc", Extra: []string{"patrol", "19", "1", "16", "1"}}
+	g := testGame(t, testMap(rows, patrol))
+	mon := g.monsterList()[0]
+	reached := false
+	for i := 0; i < ticksPerSecond*5 && !reached; i++ {
+		g.step(0)
+		reached = int(mon.sprite.x/cellSize) == 16
+	}
+	if !reached {
+		t.Errorf("patrolling monster out of sight should still walk its route, got to %.1f", mon.sprite.x)
+	}
+
+	g = testGame(t, testMap(rows, &MapFileCell{X: 19, Y: 1, Type: "m", Value: "orc"}))
+	mon = g.monsterList()[0]
+	x := mon.sprite.x
+	g.makeNoise(g.player.x, g.player.y, 20)
+	g.run(ticksPerSecond*3, 0)
+	if mon.sprite.x >= x {
+		t.Errorf("monster out of sight should come to investigate a noise, went from %.1f to %.1f", x, mon.sprite.x)
+	}
+}
+
+func TestMonsterPathAfterTeleport(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"##########",
+		"#P#......#",
+		"###......#",
+		"##########",
+	}, &MapFileCell{X: 3, Y: 1, Type: "m", Value: "orc", Extra: []string{"patrol", "8", "1"}},
+		&MapFileCell{X: 5, Y: 1, Type: "t", Value: "3,2", Extra: []string{"monsters"}}))
+	mon := g.monsterList()[0]
+
+	for i := 0; i < ticksPerSecond*5 && int(mon.sprite.y/cellSize) != 2; i++ {
+		g.step(0)
+	}
+	if int(mon.sprite.y/cellSize) != 2 {
+		t.Fatalf("orc should have been teleported, it's at %.1f,%.1f", mon.sprite.x, mon.sprite.y)
+	}
+
+	// The old path started on the other side of the pad
+	if len(mon.path) != 0 || mon.pathTarget == [2]int{8, 1} {
+		t.Errorf("orc should find a new path after teleporting, still has %v to %v", mon.path, mon.pathTarget)
+	}
+}
+
+func TestKillWithoutDeadImage(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#####",
+		"#P.o#",
+		"#####",
+	}))
+	mon := g.monsterList()[0]
+
+	// Images can go missing after the definitions were loaded
+	def := *mon.def
+	def.Dead = "missing"
+	mon.def = &def
+	mon.kill(g)
+	g.run(ticksPerSecond, 0)
+	if len(g.monsters) != 0 {
+		t.Errorf("monster should have been killed")
+	}
+}
+
+func TestAmbushIgnoresNoise(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"##########",
+		"#P......o#",
+		"##########",
+	}, &MapFileCell{X: 8, Y: 1, Type: "m", Value: "orc", Extra: []string{"ambush"}}))
+	mon := g.monsterList()[0]
+
+	// Footsteps a few cells away, then magic fired across the map
+	g.player.moveToCell(5, 1)
+	g.makeNoise(g.player.x, g.player.y, noiseFootstep)
+	g.makeNoise(g.player.x, g.player.y, noiseAttack)
+	g.step(0)
+	if mon.state != MonsterStateAmbush {
+		t.Fatalf("noise woke the ambusher, state is %v", mon.state)
+	}
+
+	// Hurting it still does
+	mon.damage(g, 1)
+	if mon.state == MonsterStateAmbush {
+		t.Error("ambusher should wake up when hurt")
+	}
+}
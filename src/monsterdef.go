@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+const monsterDefsFile = "./data/monsters.json"
+
+// All monster archetypes, keyed by name as used in map files
+var monsterDefs map[string]*MonsterDef
+
+// Stats & behaviour of a type of monster, loaded from the monster definitions file
+type MonsterDef struct {
+	name        string
+	Health      int            `json:"health"`
+	MeleeDamage int            `json:"meleeDamage"`
+	Speed       [2]float64     `json:"speed"` // Min & max, each monster gets a random speed in this range
+	Alpha       float64        `json:"alpha"`
+	Frames      []string       `json:"frames"`     // Images in gfx/monsters to animate between
+	FrameTicks  int            `json:"frameTicks"` // How long each frame is shown
+	Dead        string         `json:"dead"`       // Image shown briefly when killed
+	Projectile  *ProjectileDef `json:"projectile"`
+	Sounds      MonsterSounds  `json:"sounds"`
+	Behaviours  []string       `json:"behaviours"`
+	Drops       []MonsterDrop  `json:"drops"`
+
+	FleeHealth   float64 `json:"fleeHealth"`   // Runs away when health drops below this fraction, 0 never flees
+	KeepDistance float64 `json:"keepDistance"` // How many cells ranged monsters try to stay away from the player
+}
+
+type ProjectileDef struct {
+	Kind   string  `json:"kind"` // Image in gfx/effects
+	Damage int     `json:"damage"`
+	Chance float64 `json:"chance"` // Chance of firing each tick, out of 10000
+	Speed  float64 `json:"speed"`  // Multiplier of the normal projectile speed
+}
+
+type MonsterSounds struct {
+	See    string `json:"see"`
+	Attack string `json:"attack"`
+	Shoot  string `json:"shoot"`
+	Hit    string `json:"hit"`
+	Death  string `json:"death"`
+}
+
+// An item which might be left behind when the monster is killed
+type MonsterDrop struct {
+	Item   string  `json:"item"`
+	Chance float64 `json:"chance"` // 0 to 1
+}
+
+// Behaviours a monster can have
+const (
+	behaviourMelee  = "melee"  // Chases the player and attacks up close
+	behaviourRanged = "ranged" // Keeps its distance and fires projectiles
+)
+
+// Defaults for anything not set in the definitions file
+func newMonsterDef(name string) *MonsterDef {
+	return &MonsterDef{
+		name:        name,
+		Health:      10,
+		MeleeDamage: 10,
+		Speed:       [2]float64{1, 1},
+		Alpha:       1,
+		Frames:      []string{name},
+		FrameTicks:  20,
+		Dead:        name + "-dead",
+		Sounds: MonsterSounds{
+			See:    "monster_grunt",
+			Attack: "monster_attack",
+			Shoot:  "whoosh",
+			Hit:    "monster_hit",
+			Death:  "monster_death",
+		},
+		Behaviours:   []string{behaviourMelee},
+		KeepDistance: 3,
+	}
+}
+
+// ===========================================================
+// Load the monster definitions file, this runs headless so it
+// can be used by the validate subcommand
+// ===========================================================
+func loadMonsterDefs() error {
+	data, err := ioutil.ReadFile(monsterDefsFile)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("monster definitions are not valid JSON: %v", err)
+	}
+
+	monsterDefs = map[string]*MonsterDef{}
+	for name, rawDef := range raw {
+		def := newMonsterDef(name)
+		if err := json.Unmarshal(rawDef, def); err != nil {
+			return fmt.Errorf("monster '%s' is not valid: %v", name, err)
+		}
+		if def.Speed[1] < def.Speed[0] {
+			def.Speed[1] = def.Speed[0]
+		}
+		if len(def.Frames) == 0 {
+			def.Frames = []string{name}
+		}
+		if def.FrameTicks <= 0 {
+			def.FrameTicks = 20
+		}
+
+		// Monsters are drawn with these as soon as they're spawned or killed, so they have to exist
+		for _, frame := range def.Frames {
+			if !imageExists("monsters/" + frame) {
+				return fmt.Errorf("monster '%s' has unknown frame image '%s'", name, frame)
+			}
+		}
+		if !imageExists("monsters/" + def.Dead) {
+			return fmt.Errorf("monster '%s' has unknown dead image '%s'", name, def.Dead)
+		}
+		for _, behaviour := range def.Behaviours {
+			if behaviour != behaviourMelee && behaviour != behaviourRanged {
+				log.Printf("WARNING! Monster '%s' has unknown behaviour '%s'", name, behaviour)
+			}
+		}
+		if def.hasBehaviour(behaviourRanged) && def.Projectile == nil {
+			log.Printf("WARNING! Monster '%s' is ranged but has no projectile", name)
+		}
+		monsterDefs[name] = def
+	}
+
+	log.Printf("Loaded %d monster definitions", len(monsterDefs))
+	return nil
+}
+
+func (d *MonsterDef) hasBehaviour(behaviour string) bool {
+	for _, b := range d.Behaviours {
+		if b == behaviour {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of all monsters, sorted so they are listed in a stable order
+func monsterNames() []string {
+	names := []string{}
+	for name := range monsterDefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
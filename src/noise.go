@@ -0,0 +1,52 @@
+package main
+
+import "math"
+
+// How loud noises are, this is how many open cells they carry through
+const (
+	noiseFootstep = 3
+	noiseDoor     = 8
+	noiseSwitch   = 8
+	noiseAttack   = 12
+	noiseMelee    = 5
+)
+
+// ===========================================================
+// Make a noise at a point, it spreads out through open cells getting
+// quieter as it goes, any monsters that hear it come to investigate
+// ===========================================================
+func (g *Game) makeNoise(x, y float64, loudness int) {
+	startX, startY := int(x/cellSize), int(y/cellSize)
+	if !g.inBounds(startX, startY) {
+		return
+	}
+
+	// Walls & closed doors block sound, the start cell can be a wall e.g. a switch
+	heard := map[[2]int]bool{{startX, startY}: true}
+	edge := [][2]int{{startX, startY}}
+	for volume := loudness; volume > 0 && len(edge) > 0; volume-- {
+		next := [][2]int{}
+		for _, cell := range edge {
+			cx, cy := cell[0], cell[1]
+			for _, n := range [][2]int{{cx + 1, cy}, {cx - 1, cy}, {cx, cy + 1}, {cx, cy - 1}} {
+				if heard[n] || !g.isWalkable(n[0], n[1]) {
+					continue
+				}
+				heard[n] = true
+				next = append(next, n)
+			}
+		}
+		edge = next
+	}
+
+	for _, mon := range g.monsterList() {
+		if !heard[[2]int{int(mon.sprite.x / cellSize), int(mon.sprite.y / cellSize)}] {
+			continue
+		}
+		// Ambushers lie in wait, only something right next to them gives it away
+		if mon.state == MonsterStateAmbush && math.Hypot(mon.sprite.x-x, mon.sprite.y-y) >= ambushRange {
+			continue
+		}
+		mon.alert(x, y)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// Give up searching after this many cells, stops huge maps getting slow
+const maxPathNodes = 2000
+
+type pathNode struct {
+	cell  [2]int
+	cost  int // Steps from the start
+	score int // Cost plus estimate to the goal
+	index int
+}
+
+// Priority queue of nodes, lowest score first
+type pathQueue []*pathNode
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].score < q[j].score }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i]; q[i].index = i; q[j].index = j }
+func (q *pathQueue) Push(x interface{}) { n := x.(*pathNode); n.index = len(*q); *q = append(*q, n) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// Monsters can't open doors, so closed doors block the same as walls & furniture
+func (g *Game) isWalkable(x, y int) bool {
+	if !g.inBounds(x, y) {
+		return false
+	}
+	wall := g.mapdata[x][y]
+	return wall == nil || (wall.door != nil && wall.door.isOpen())
+}
+
+// The player or a monster is standing in the cell, or partly in it
+func (g *Game) cellOccupied(x, y int) bool {
+	cx, cy := float64(x)*cellSize+cellSize/2, float64(y)*cellSize+cellSize/2
+	if math.Abs(g.player.x-cx) < cellSize/2+g.player.size && math.Abs(g.player.y-cy) < cellSize/2+g.player.size {
+		return true
+	}
+	for _, mon := range g.monsterList() {
+		if math.Abs(mon.sprite.x-cx) < cellSize/2+mon.sprite.size && math.Abs(mon.sprite.y-cy) < cellSize/2+mon.sprite.size {
+			return true
+		}
+	}
+	return false
+}
+
+// ===========================================================
+// Find a route between two cells using A*, returns the cells to walk
+// through not including the start, or nil if there's no route
+// ===========================================================
+func (g *Game) findPath(fromX, fromY, toX, toY int) [][2]int {
+	start := [2]int{fromX, fromY}
+	goal := [2]int{toX, toY}
+	if start == goal || !g.isWalkable(toX, toY) {
+		return nil
+	}
+
+	estimate := func(c [2]int) int {
+		dx, dy := c[0]-goal[0], c[1]-goal[1]
+		if dx < 0 {
+			dx = -dx
+		}
+		if dy < 0 {
+			dy = -dy
+		}
+		return dx + dy
+	}
+
+	queue := &pathQueue{}
+	heap.Push(queue, &pathNode{cell: start, score: estimate(start)})
+	cameFrom := map[[2]int][2]int{}
+	costs := map[[2]int]int{start: 0}
+
+	for visited := 0; queue.Len() > 0 && visited < maxPathNodes; visited++ {
+		node := heap.Pop(queue).(*pathNode)
+		if node.cell == goal {
+			path := [][2]int{}
+			for c := goal; c != start; c = cameFrom[c] {
+				path = append([][2]int{c}, path...)
+			}
+			return path
+		}
+
+		x, y := node.cell[0], node.cell[1]
+		for _, next := range [][2]int{{x + 1, y}, {x - 1, y}, {x, y + 1}, {x, y - 1}} {
+			if !g.isWalkable(next[0], next[1]) {
+				continue
+			}
+			cost := node.cost + 1
+			if old, ok := costs[next]; ok && old <= cost {
+				continue
+			}
+			costs[next] = cost
+			cameFrom[next] = node.cell
+			heap.Push(queue, &pathNode{cell: next, cost: cost, score: cost + estimate(next)})
+		}
+	}
+
+	return nil
+}
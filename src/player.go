@@ -3,10 +3,6 @@ package main
 import (
 	"fmt"
 	"math"
-	"math/rand"
-	"time"
-
-	"github.com/hajimehoshi/ebiten/v2"
 )
 
 type Player struct {
@@ -18,62 +14,80 @@ type Player struct {
 	health int
 	mana   int
 
-	playingFootsteps bool
+	footstepTick int // Tick the next footstep sound can play
 
 	// These are effectively constants, but we hold them in the player
 	fov  float64 // Field of view
 	size float64 // Used for collision detection with walls
 
-	// These handle movement and turning
-	moveStartTime int64
-	moveFunc      func(int64) float64
-	turnStartTime int64
-	turnFunc      func(int64) float64
+	// These handle movement and turning, times are in ticks
+	moveStartTick int
+	moveFunc      func(int) float64
+	turnStartTick int
+	turnFunc      func(int) float64
 
 	holding map[string]int
+	buffs   map[string]int // Ticks left on each temporary buff
+
+	weapon     string // Name of the weapon in hand
+	lastAttack int    // Tick of the last attack, for the weapon's fire rate
 
 	justFired bool
 }
 
 func newPlayer(cellX, cellY int) Player {
-	return Player{
-		x:                cellSize*float64(cellX) + cellSize/2,
-		y:                cellSize*float64(cellY) + cellSize/2,
-		angle:            0,
-		moveStartTime:    0.0,
-		turnStartTime:    0.0,
-		fov:              fov,
-		size:             cellSize / 16.0,
-		playingFootsteps: false,
-		health:           100,
-		mana:             100,
-		cellX:            cellX,
-		cellY:            cellY,
-		holding:          map[string]int{},
-
-		moveFunc: func(t int64) float64 {
+	p := Player{
+		x:             cellSize*float64(cellX) + cellSize/2,
+		y:             cellSize*float64(cellY) + cellSize/2,
+		angle:         0,
+		moveStartTick: 0,
+		turnStartTick: 0,
+		fov:           viewFov,
+		size:          cellSize / 16.0,
+		footstepTick:  0,
+		health:        100,
+		mana:          100,
+		cellX:         cellX,
+		cellY:         cellY,
+		holding:       map[string]int{},
+		buffs:         map[string]int{},
+		weapon:        startWeapon(),
+		lastAttack:    -1000,
+
+		// Accelerate over a quarter of a second
+		moveFunc: func(t int) float64 {
 			min := float64(cellSize) / 50.0
 			max := float64(cellSize) / 14.0
-			return math.Min(min+math.Pow(float64(t)/250000, 2), max)
+			return math.Min(min+math.Pow(float64(t)/15, 2), max)
 		},
 
-		turnFunc: func(t int64) float64 {
+		turnFunc: func(t int) float64 {
 			min := math.Pi / 300.0
 			max := math.Pi / 70.0
-			return math.Min(min+math.Pow(float64(t)/800_000, 3), max)
+			return math.Min(min+math.Pow(float64(t)/48, 3), max)
 		},
 	}
+
+	for _, def := range weaponOrder {
+		if def.Start {
+			p.holding[def.name] = 1
+		}
+	}
+	return p
 }
 
-func (p *Player) turn(t int64, direction float64) {
+func (p *Player) turn(t int, direction float64) {
 	p.angle = p.angle + p.turnFunc(t)*direction
 }
 
-func (p *Player) move(t int64, direction float64, strafe int) {
+func (p *Player) move(g *Game, t int, direction float64, strafe int) {
 	// Invoke the move function
 	speed := p.moveFunc(t)
+	if p.buffs[buffSpeed] > 0 {
+		speed *= 1.5
+	}
 
-	if ebiten.IsKeyPressed(ebiten.KeyDown) || ebiten.IsKeyPressed(ebiten.KeyS) {
+	if g.input.isHeld(actionBack) {
 		speed = -speed
 	}
 
@@ -88,7 +102,7 @@ func (p *Player) move(t int64, direction float64, strafe int) {
 	newY := p.y + math.Sin(angle)*speed
 
 	// Check if we're going to collide with a wall
-	if wall, cx, cy := p.checkWallCollision(newX, newY); wall != nil {
+	if wall, cx, cy := p.checkWallCollision(g, newX, newY); wall != nil {
 		// Hit a wall, work out if we stop or slide
 		wx, wy := wall.getCenter()
 
@@ -113,29 +127,34 @@ func (p *Player) move(t int64, direction float64, strafe int) {
 	}
 
 	// Update player position
+	oldX, oldY := p.x, p.y
 	p.x = newX
 	p.y = newY
 	p.cellX = int(math.Floor(p.x / cellSize))
 	p.cellY = int(math.Floor(p.y / cellSize))
 
+	// Stepping onto a teleporter pad
+	if t := g.padAt(oldX, oldY, p.x, p.y); t != nil {
+		g.teleportPlayer(t.dest, t.facing)
+	}
+
 	// Check items near the player we're in and pick them up
-	for _, item := range game.items {
+	for _, item := range g.itemList() {
 		if item.cellX != p.cellX || item.cellY != p.cellY {
 			continue
 		}
 
-		item.pickUpFunc(p)
-		game.removeItem(item)
+		item.pickUpFunc(g)
+		g.removeItem(item)
+		g.triggerPickup(item)
+		g.runScript("onPickup", item.def.name, float64(item.cellX), float64(item.cellY))
 	}
 
 	// Footstep sound
-	if !p.playingFootsteps {
-		playSound(fmt.Sprintf("footstep_%d", rand.Intn(4)), 0.5, true)
-		p.playingFootsteps = true
-
-		time.AfterFunc(300*time.Millisecond, func() {
-			p.playingFootsteps = false
-		})
+	if g.ticks >= p.footstepTick {
+		playSound(fmt.Sprintf("footstep_%d", g.rng.Intn(4)), 0.5, true)
+		p.footstepTick = g.ticks + ticksPerSecond*3/10
+		g.makeNoise(p.x, p.y, noiseFootstep)
 	}
 }
 
@@ -147,61 +166,160 @@ func (p *Player) moveToCell(cellX, cellY int) {
 }
 
 func (p *Player) setFacing(facing int) {
-	// facing: 0 = up, 1 = right, 2 = down, 3 = left
-	p.angle = math.Pi / 2 * float64(facing-1)
+	p.angle = facingAngle(facing)
+}
+
+// facing: 0 = up, 1 = right, 2 = down, 3 = left
+func facingAngle(facing int) float64 {
+	return math.Pi / 2 * float64(facing-1)
 }
 
-func (p *Player) checkWallCollision(x, y float64) (*Wall, float64, float64) {
-	if wall := game.getWallAt(x+p.size, y); wall != nil {
+func (p *Player) checkWallCollision(g *Game, x, y float64) (*Wall, float64, float64) {
+	if wall := g.getWallAt(x+p.size, y); wall != nil {
 		return wall, x + p.size, y
 	}
-	if wall := game.getWallAt(x-p.size, y); wall != nil {
+	if wall := g.getWallAt(x-p.size, y); wall != nil {
 		return wall, x - p.size, y
 	}
-	if wall := game.getWallAt(x, y+p.size); wall != nil {
+	if wall := g.getWallAt(x, y+p.size); wall != nil {
 		return wall, x, y + p.size
 	}
-	if wall := game.getWallAt(x, y-p.size); wall != nil {
+	if wall := g.getWallAt(x, y-p.size); wall != nil {
 		return wall, x, y - p.size
 	}
 	return nil, 0, 0
 }
 
-func (p Player) use() {
-	if wall, _ := fireRayAngle(p.x, p.y, p.angle, cellSize); wall != nil {
-		wall.actionFunc(game)
+func (p Player) use(g *Game) {
+	// Reach a bit further than one cell, doors are set back into their cells
+	wall, _ := g.fireRayAngle(p.x, p.y, p.angle, cellSize*1.5)
+	if wall == nil {
+		return
+	}
+	// The level's script gets first go, and can stop the wall doing anything
+	if scriptTruthy(g.runScript("onUse", float64(wall.x), float64(wall.y))) {
+		return
+	}
+	if wall.actionFunc != nil {
+		wall.actionFunc(g)
 	}
 }
 
-func (p *Player) attack() {
-	if p.mana <= 0 {
+func (p *Player) attack(g *Game) {
+	weapon := weaponDefs[p.weapon]
+	if weapon == nil || g.ticks-p.lastAttack < weapon.Rate {
+		return
+	}
+	if weapon.Mana > 0 && p.mana <= 0 {
 		return
 	}
+	p.lastAttack = g.ticks
 
 	// Show the attack animation
 	p.justFired = true
-	forceHudUpdate = true
+	g.forceHudUpdate = true
 
-	playSound("zap", 0.3, false)
+	playSound(weapon.Sound, 0.3, false)
 
-	p.mana -= 5
+	p.mana -= weapon.Mana
 	if p.mana < 0 {
 		p.mana = 0.0
 	}
 
-	sx := p.x + ((cellSize / 3) * math.Cos(p.angle))
-	sy := p.y + ((cellSize / 3) * math.Sin(p.angle))
-	game.addProjectile("magic", sx, sy, p.angle, (float64(cellSize) / 5.0), 40, 0.6)
+	if weapon.Melee {
+		g.makeNoise(p.x, p.y, noiseMelee)
+		p.meleeAttack(g, weapon.Range*cellSize, weapon.Damage)
+		return
+	}
+	g.makeNoise(p.x, p.y, noiseAttack)
+
+	// The damage buff only powers up projectiles
+	damage := weapon.Damage
+	if p.buffs[buffDamage] > 0 {
+		damage *= 2
+	}
+
+	// Multiple projectiles are fanned out either side of where the player is facing
+	for i := 0; i < weapon.Count; i++ {
+		angle := p.angle + (float64(i)-float64(weapon.Count-1)/2)*weapon.Spread
+		sx := p.x + ((cellSize / 3) * math.Cos(angle))
+		sy := p.y + ((cellSize / 3) * math.Sin(angle))
+		if proj := g.addProjectile(weapon.Projectile, sx, sy, angle, weapon.Speed*cellSize, damage, weapon.Alpha); proj != nil {
+			proj.piercing = weapon.Piercing
+		}
+	}
+}
+
+// Hit the nearest monster in front of the player, if there's one in reach
+func (p *Player) meleeAttack(g *Game, reach float64, damage int) {
+	var target *Monster
+	targetDist := reach
+	for _, mon := range g.monsterList() {
+		dist := mon.sprite.getDistanceToPlayer(*p) - mon.sprite.size
+		angle := math.Atan2(mon.sprite.y-p.y, mon.sprite.x-p.x) - p.angle
+		angle = math.Atan2(math.Sin(angle), math.Cos(angle))
+		if dist > targetDist || math.Abs(angle) > math.Pi/5 {
+			continue
+		}
+		if wall, _, _ := g.fireRayAt(p.x, p.y, mon.sprite.x, mon.sprite.y, dist); wall != nil {
+			continue
+		}
+		target, targetDist = mon, dist
+	}
+
+	if target != nil {
+		target.damage(g, damage)
+	}
+}
+
+// Switch to a weapon, as long as the player is holding it
+func (p *Player) selectWeapon(g *Game, name string) {
+	def := weaponDefs[name]
+	if def == nil || p.holding[name] <= 0 || p.weapon == name {
+		return
+	}
+	p.weapon = name
+	g.forceHudUpdate = true
+	playSound("menu_click", 0.5, false)
+	g.showMessage(def.Title)
+}
+
+// Cycle through the weapons the player is holding, in slot order
+func (p *Player) nextWeapon(g *Game, direction int) {
+	current := 0
+	for i, def := range weaponOrder {
+		if def.name == p.weapon {
+			current = i
+		}
+	}
+	for n := 1; n < len(weaponOrder); n++ {
+		i := (current + direction*n + len(weaponOrder)*n) % len(weaponOrder)
+		if p.holding[weaponOrder[i].name] > 0 {
+			p.selectWeapon(g, weaponOrder[i].name)
+			return
+		}
+	}
 }
 
 // damage the player
-func (p *Player) damage(amount int) {
-	screenFlashRed(10)
+func (p *Player) damage(g *Game, amount int) {
+	g.screenFlashRed(10)
 	p.health -= amount
 	if p.health <= 0 {
 		p.health = 0
 		playSound("scream", 1, false)
-		game.gameOver()
+		g.gameOver()
 	}
 	playSound("pain", 1, false)
 }
+
+// Count down any temporary buffs, called every tick
+func (p *Player) updateBuffs() {
+	for buff, ticks := range p.buffs {
+		if ticks <= 1 {
+			delete(p.buffs, buff)
+			continue
+		}
+		p.buffs[buff] = ticks - 1
+	}
+}
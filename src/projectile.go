@@ -2,35 +2,45 @@ package main
 
 import (
 	"math"
-	"math/rand"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
 
+// Normal speed of projectiles fired by monsters
+const projectileSpeed = float64(cellSize) / 9.0
+
 type Projectile struct {
-	id     uint64
-	sprite *Sprite
-	damage int
+	id       uint64
+	sprite   *Sprite
+	damage   int
+	piercing bool            // Passes through monsters rather than stopping at the first
+	hit      map[uint64]bool // Monsters a piercing projectile has already hit
 }
 
-func (g *Game) addProjectile(kind string, x, y float64, angle float64, speed float64, damage int, alpha float64) {
+func (g *Game) addProjectile(kind string, x, y float64, angle float64, speed float64, damage int, alpha float64) *Projectile {
 	s := g.addSprite("effects/"+kind, x, y, angle, speed, cellSize/16.0)
+	if s == nil {
+		return nil
+	}
 	s.alpha = alpha
 
-	id := rand.Uint64()
-	g.projectiles[id] = &Projectile{
+	id := g.newID()
+	p := &Projectile{
 		id:     id,
 		sprite: s,
 		damage: damage,
+		hit:    map[uint64]bool{},
 	}
+	g.projectiles[id] = p
+	return p
 }
 
 func (g *Game) updateProjectiles() {
-	for id := range g.projectiles {
-		sprite := g.projectiles[id].sprite
+	for _, proj := range g.projectileList() {
+		sprite := proj.sprite
 
 		// Animate and rotate the projectile sprite every 5 frames
-		if g.ticks%5 == 0 {
+		if g.ticks%5 == 0 && !g.headless {
 			rotatedImg := ebiten.NewImageFromImage(sprite.image)
 			rotateOp := &ebiten.DrawImageOptions{}
 			rotateOp.GeoM.Translate(-spriteImgSizeH, -spriteImgSizeH)
@@ -44,29 +54,40 @@ func (g *Game) updateProjectiles() {
 		newX := sprite.x + math.Cos(sprite.angle)*sprite.speed
 		newY := sprite.y + math.Sin(sprite.angle)*sprite.speed
 		if wall := g.getWallAt(newX, newY); wall != nil {
-			g.removeProjectile(g.projectiles[id])
+			g.removeProjectile(proj)
+			continue
 		}
 
 		// Check if it hit a monster
-		for _, m := range g.monsters {
-			if m.sprite.isHit(newX, newY) {
-				if m == nil || g.projectiles[id] == nil {
-					continue
-				}
-				m.damage(g.projectiles[id].damage)
-				g.removeProjectile(g.projectiles[id])
+		hitMonster := false
+		for _, m := range g.monsterList() {
+			if !m.sprite.isHit(newX, newY) || proj.hit[m.id] {
+				continue
 			}
+			proj.hit[m.id] = true
+			m.damage(g, proj.damage)
+			if !proj.piercing {
+				g.removeProjectile(proj)
+				hitMonster = true
+				break
+			}
+		}
+		if hitMonster {
+			continue
 		}
 
 		// Check if it hit the player
 		playerDist := sprite.getDistanceToPlayer(g.player)
 		if playerDist < (g.player.size*3 + sprite.size) {
-			g.player.damage(g.projectiles[id].damage)
-			g.removeProjectile(g.projectiles[id])
+			g.player.damage(g, proj.damage)
+			g.removeProjectile(proj)
+			continue
 		}
 
+		oldX, oldY := sprite.x, sprite.y
 		sprite.x = newX
 		sprite.y = newY
+		g.teleportSprite(sprite, oldX, oldY, false)
 	}
 }
 
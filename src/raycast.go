@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+)
+
+// Which face of a map cell a ray hit
+type WallSide int
+
+const (
+	WallSideNorth WallSide = iota
+	WallSideSouth
+	WallSideEast
+	WallSideWest
+)
+
+// Result of casting a ray through the map grid
+type RayHit struct {
+	wall  *Wall
+	cellX int
+	cellY int
+	side  WallSide
+	dist  float64 // Exact distance along the ray to the hit point
+	x     float64 // Hit point in world space
+	y     float64
+	texU  float64 // Horizontal texture coordinate of the hit, 0.0 ~ 1.0
+}
+
+// ===========================================================
+// Cast a ray through the map using a grid DDA (digital differential analyzer)
+// Every cell boundary the ray crosses is visited exactly once, so the cost depends
+// on the number of cells traversed rather than the ray length
+// ===========================================================
+func (g *Game) castRay(x, y float64, angle float64, maxDist float64, seeInvisible bool) (hit RayHit, ok bool) {
+	dirX := math.Cos(angle)
+	dirY := math.Sin(angle)
+
+	cellX := int(math.Floor(x / cellSize))
+	cellY := int(math.Floor(y / cellSize))
+
+	// Distance along the ray between successive vertical & horizontal grid lines
+	deltaX := math.Inf(1)
+	deltaY := math.Inf(1)
+	// Distance along the ray to the first vertical & horizontal grid lines
+	sideDistX := math.Inf(1)
+	sideDistY := math.Inf(1)
+	stepX := 0
+	stepY := 0
+
+	if dirX > 0 {
+		stepX = 1
+		deltaX = cellSize / dirX
+		sideDistX = (float64(cellX+1)*cellSize - x) / dirX
+	} else if dirX < 0 {
+		stepX = -1
+		deltaX = cellSize / -dirX
+		sideDistX = (x - float64(cellX)*cellSize) / -dirX
+	}
+	if dirY > 0 {
+		stepY = 1
+		deltaY = cellSize / dirY
+		sideDistY = (float64(cellY+1)*cellSize - y) / dirY
+	} else if dirY < 0 {
+		stepY = -1
+		deltaY = cellSize / -dirY
+		sideDistY = (y - float64(cellY)*cellSize) / -dirY
+	}
+
+	for {
+		var dist float64
+		var side WallSide
+		if sideDistX < sideDistY {
+			dist = sideDistX
+			sideDistX += deltaX
+			cellX += stepX
+			side = WallSideWest
+			if stepX < 0 {
+				side = WallSideEast
+			}
+		} else {
+			dist = sideDistY
+			sideDistY += deltaY
+			cellY += stepY
+			side = WallSideNorth
+			if stepY < 0 {
+				side = WallSideSouth
+			}
+		}
+
+		if dist > maxDist {
+			return RayHit{}, false
+		}
+		if !g.inBounds(cellX, cellY) {
+			return RayHit{}, false
+		}
+
+		wall := g.mapdata[cellX][cellY]
+		if wall == nil || (wall.invisible && !seeInvisible) {
+			continue
+		}
+
+		if wall.door != nil {
+			doorDist, texU, doorSide, hitDoor := wall.door.rayHit(x, y, dirX, dirY, dist)
+			if !hitDoor || doorDist > maxDist {
+				continue
+			}
+			return RayHit{
+				wall:  wall,
+				cellX: cellX,
+				cellY: cellY,
+				side:  doorSide,
+				dist:  doorDist,
+				x:     x + dirX*doorDist,
+				y:     y + dirY*doorDist,
+				texU:  texU,
+			}, true
+		}
+
+		hit = RayHit{
+			wall:  wall,
+			cellX: cellX,
+			cellY: cellY,
+			side:  side,
+			dist:  dist,
+			x:     x + dirX*dist,
+			y:     y + dirY*dist,
+		}
+
+		// Texture coordinate, flipped on two sides so textures always read left to right
+		switch side {
+		case WallSideWest:
+			hit.texU = hit.y/cellSize - float64(cellY)
+		case WallSideEast:
+			hit.texU = 1 - (hit.y/cellSize - float64(cellY))
+		case WallSideNorth:
+			hit.texU = 1 - (hit.x/cellSize - float64(cellX))
+		case WallSideSouth:
+			hit.texU = hit.x/cellSize - float64(cellX)
+		}
+		hit.texU = math.Max(0, math.Min(hit.texU, 1))
+
+		return hit, true
+	}
+}
+
+// Angle of the ray passing through a given screen column, rays are spaced evenly
+// across the flat projection plane rather than evenly by angle, avoiding distortion
+func (p Player) rayAngleAt(screenX float64) float64 {
+	return p.angle + math.Atan((screenX-float64(winWidth)/2)/projDist)
+}
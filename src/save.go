@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Current version of the save file format, bump this and add a migration step when it changes
+const saveFileVersion = 2
+const saveDir = "./saves"
+
+// Slot used by the quick save & load keys
+const quickSaveSlot = "quick"
+
+// Slots selectable from the pause menu
+var saveSlots = []string{quickSaveSlot, "1", "2", "3"}
+
+type SaveFile struct {
+	Version     int               `json:"version"`
+	MapName     string            `json:"mapName"`
+	Ticks       int               `json:"ticks"`
+	SavedAt     time.Time         `json:"savedAt"`
+	Player      SavedPlayer       `json:"player"`
+	Monsters    []SavedMonster    `json:"monsters"`
+	Items       [][2]int          `json:"items"`             // Cells of items not yet picked up
+	Dropped     []SavedItem       `json:"dropped"`           // Items left behind by monsters
+	Removed     [][2]int          `json:"removed"`           // Walls & doors opened or removed since the level started
+	Pressed     [][2]int          `json:"pressed,omitempty"` // Switches that have been pressed, only in version 1
+	Doors       []SavedDoor       `json:"doors"`
+	Switches    []SavedSwitch     `json:"switches"`
+	Triggers    []SavedTrigger    `json:"triggers"` // In the same order as the map file
+	Light       float64           `json:"light"`
+	Script      *SavedScript      `json:"script,omitempty"`
+	Seen        [][2]int          `json:"seen"` // Walls the player has seen, for the map overlay
+	Projectiles []SavedProjectile `json:"projectiles"`
+	Stats       SavedStats        `json:"stats"`
+	Difficulty  string            `json:"difficulty"`
+	Seed        int64             `json:"seed"`
+	Draws       uint64            `json:"draws"` // Random numbers used so far, so the game carries on the same
+
+	// Only set when playing a campaign
+	Campaign      string     `json:"campaign,omitempty"`
+	CampaignLevel int        `json:"campaignLevel,omitempty"`
+	CampaignStats SavedStats `json:"campaignStats"`
+}
+
+type SavedPlayer struct {
+	X       float64        `json:"x"`
+	Y       float64        `json:"y"`
+	Angle   float64        `json:"angle"`
+	Health  int            `json:"health"`
+	Mana    int            `json:"mana"`
+	Holding map[string]int `json:"holding"`
+	Buffs   map[string]int `json:"buffs"`
+	Weapon  string         `json:"weapon"`
+
+	LastAttack    int    `json:"lastAttack"` // Tick of the last attack, weapons can't fire again straight away
+	MoveStartTick int    `json:"moveStartTick"`
+	TurnStartTick int    `json:"turnStartTick"`
+	FootstepTick  int    `json:"footstepTick"`
+	Actions       uint32 `json:"actions"` // Held at the time, so they don't count as pressed again
+}
+
+type SavedMonster struct {
+	Kind        string       `json:"kind"`
+	X           float64      `json:"x"`
+	Y           float64      `json:"y"`
+	Angle       float64      `json:"angle"`
+	Speed       float64      `json:"speed"`
+	BaseSpeed   float64      `json:"baseSpeed"`
+	Health      int          `json:"health"`
+	State       MonsterState `json:"state"`
+	StateTicker int          `json:"stateTicker"`
+	SeenPlayer  bool         `json:"seenPlayer"`
+	LastSeenX   float64      `json:"lastSeenX"`
+	LastSeenY   float64      `json:"lastSeenY"`
+	Patrol      [][2]int     `json:"patrol"`
+	PatrolIndex int          `json:"patrolIndex"`
+	Goal        [2]int       `json:"goal"`
+	Path        [][2]int     `json:"path"`
+	PathTarget  [2]int       `json:"pathTarget"`
+}
+
+type SavedItem struct {
+	Kind string `json:"kind"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+type SavedProjectile struct {
+	Kind     string  `json:"kind"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Angle    float64 `json:"angle"`
+	Speed    float64 `json:"speed"`
+	Alpha    float64 `json:"alpha"`
+	Damage   int     `json:"damage"`
+	Piercing bool    `json:"piercing"`
+}
+
+type SavedDoor struct {
+	X        int       `json:"x"`
+	Y        int       `json:"y"`
+	State    DoorState `json:"state"`
+	Open     float64   `json:"open"`
+	Timer    int       `json:"timer"`
+	Locked   bool      `json:"locked"`
+	StayOpen bool      `json:"stayOpen"`
+	Held     bool      `json:"held"`
+}
+
+type SavedSwitch struct {
+	X         int      `json:"x"`
+	Y         int      `json:"y"`
+	On        bool     `json:"on"`
+	OffTick   int      `json:"offTick"`
+	Created   [][2]int `json:"created"` // Walls put up by the switch
+	LastLight float64  `json:"lastLight"`
+}
+
+type SavedTrigger struct {
+	Fired  bool  `json:"fired"`
+	Due    int   `json:"due"`
+	Guards []int `json:"guards"` // Positions in the monster list, for cleared triggers
+}
+
+type SavedScript struct {
+	Started bool                   `json:"started"`
+	Failed  bool                   `json:"failed"`
+	Globals map[string]interface{} `json:"globals"`
+	Walls   []SavedWall            `json:"walls"` // Walls put up by the script
+}
+
+type SavedWall struct {
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Texture string `json:"texture"`
+}
+
+type SavedStats struct {
+	Monsters     int           `json:"monsters"`
+	Kills        int           `json:"kills"`
+	ItemsTotal   int           `json:"itemsTotal"`
+	ItemsFound   int           `json:"itemsFound"`
+	SecretsTotal int           `json:"secretsTotal"`
+	SecretsFound int           `json:"secretsFound"`
+	Score        int           `json:"score"`
+	DoorsOpened  int           `json:"doorsOpened"`
+	Elapsed      time.Duration `json:"elapsed"`
+}
+
+func saveFilePath(slot string) string {
+	return fmt.Sprintf("%s/%s.json", saveDir, slot)
+}
+
+// Copy of the player's state, the maps are copied so later changes don't affect it
+func (p *Player) saved() SavedPlayer {
+	s := SavedPlayer{
+		X:       p.x,
+		Y:       p.y,
+		Angle:   p.angle,
+		Health:  p.health,
+		Mana:    p.mana,
+		Holding: map[string]int{},
+		Buffs:   map[string]int{},
+		Weapon:  p.weapon,
+
+		LastAttack:    p.lastAttack,
+		MoveStartTick: p.moveStartTick,
+		TurnStartTick: p.turnStartTick,
+		FootstepTick:  p.footstepTick,
+	}
+	for name, count := range p.holding {
+		s.Holding[name] = count
+	}
+	for name, ticks := range p.buffs {
+		s.Buffs[name] = ticks
+	}
+	return s
+}
+
+// ===========================================================
+// Save the whole state of the current level to a slot
+// ===========================================================
+func (g *Game) saveGame(slot string) error {
+	save, err := g.newSaveFile()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(save, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(saveDir, 0755); err != nil {
+		return err
+	}
+
+	log.Printf("Saving game to slot '%s'", slot)
+	return ioutil.WriteFile(saveFilePath(slot), data, 0644)
+}
+
+// Everything needed to carry on playing the level from this tick
+func (g *Game) newSaveFile() (*SaveFile, error) {
+	// Walls that are gone now but were in the map file must have been opened
+	mapFile, err := readMapFile(g.mapName)
+	if err != nil {
+		return nil, err
+	}
+	grid := mapFile.gridOn(g.difficultyLevel().name)
+
+	save := &SaveFile{
+		Version:    saveFileVersion,
+		MapName:    g.mapName,
+		Ticks:      g.ticks,
+		SavedAt:    time.Now(),
+		Player:     g.player.saved(),
+		Stats:      newSavedStats(g.stats, g.stats.elapsed(g.ticks)),
+		Difficulty: g.difficultyLevel().name,
+		Seed:       g.seed,
+		Draws:      g.rngSource.draws,
+	}
+
+	save.Player.Actions = g.input.held
+
+	if g.campaign != nil {
+		save.Campaign = g.campaign.name
+		save.CampaignLevel = g.campaignLevel
+		save.CampaignStats = newSavedStats(g.campaignStats, g.campaignTime)
+	}
+
+	for x := 0; x < g.mapWidth; x++ {
+		for y := 0; y < g.mapHeight; y++ {
+			wall := g.mapdata[x][y]
+			cell := grid[x][y]
+			if wall == nil && cell != nil && (cell.Type == "w" || cell.Type == "d") {
+				save.Removed = append(save.Removed, [2]int{x, y})
+			}
+			if wall != nil && wall.seen {
+				save.Seen = append(save.Seen, [2]int{x, y})
+			}
+		}
+	}
+
+	for _, door := range g.doors {
+		save.Doors = append(save.Doors, SavedDoor{
+			X:        door.x,
+			Y:        door.y,
+			State:    door.state,
+			Open:     door.open,
+			Timer:    door.timer,
+			Locked:   door.locked,
+			StayOpen: door.stayOpen,
+			Held:     door.held,
+		})
+	}
+
+	for _, sw := range g.switches {
+		saved := SavedSwitch{X: sw.wall.x, Y: sw.wall.y, On: sw.on, OffTick: sw.offTick, LastLight: sw.lastLight}
+		for cell := range sw.created {
+			saved.Created = append(saved.Created, cell)
+		}
+		sort.Slice(saved.Created, func(i, j int) bool {
+			return saved.Created[i][0] < saved.Created[j][0] || (saved.Created[i][0] == saved.Created[j][0] && saved.Created[i][1] < saved.Created[j][1])
+		})
+		save.Switches = append(save.Switches, saved)
+	}
+	save.Light = g.light
+
+	if g.script != nil {
+		save.Script = &SavedScript{Started: g.script.started, Failed: g.script.failed, Globals: map[string]interface{}{}}
+		for i, name := range g.script.names {
+			save.Script.Globals[name] = g.script.globals[i]
+		}
+		for cell, texture := range g.script.walls {
+			save.Script.Walls = append(save.Script.Walls, SavedWall{X: cell[0], Y: cell[1], Texture: texture})
+		}
+		sort.Slice(save.Script.Walls, func(i, j int) bool {
+			a, b := save.Script.Walls[i], save.Script.Walls[j]
+			return a.X < b.X || (a.X == b.X && a.Y < b.Y)
+		})
+	}
+
+	// Monsters get new IDs when loaded, so guards are saved by position in the list
+	monsters := g.monsterList()
+	monsterIndex := map[uint64]int{}
+	for i, mon := range monsters {
+		monsterIndex[mon.id] = i
+	}
+	for _, t := range g.triggers {
+		saved := SavedTrigger{Fired: t.fired, Due: t.due}
+		for _, id := range t.guards {
+			saved.Guards = append(saved.Guards, monsterIndex[id])
+		}
+		save.Triggers = append(save.Triggers, saved)
+	}
+
+	for _, item := range g.itemList() {
+		if item.dropped {
+			save.Dropped = append(save.Dropped, SavedItem{Kind: item.def.name, X: item.cellX, Y: item.cellY})
+			continue
+		}
+		save.Items = append(save.Items, [2]int{item.cellX, item.cellY})
+	}
+
+	for _, mon := range monsters {
+		save.Monsters = append(save.Monsters, SavedMonster{
+			Kind:        mon.def.name,
+			X:           mon.sprite.x,
+			Y:           mon.sprite.y,
+			Angle:       mon.sprite.angle,
+			Speed:       mon.sprite.speed,
+			BaseSpeed:   mon.baseSpeed,
+			Health:      mon.health,
+			State:       mon.state,
+			StateTicker: mon.stateTicker,
+			SeenPlayer:  mon.seenPlayer,
+			LastSeenX:   mon.lastSeenX,
+			LastSeenY:   mon.lastSeenY,
+			Patrol:      mon.patrol,
+			PatrolIndex: mon.patrolIndex,
+			Goal:        mon.goal,
+			Path:        mon.path,
+			PathTarget:  mon.pathTarget,
+		})
+	}
+
+	for _, proj := range g.projectileList() {
+		save.Projectiles = append(save.Projectiles, SavedProjectile{
+			Kind:     strings.TrimPrefix(proj.sprite.kind, "effects/"),
+			X:        proj.sprite.x,
+			Y:        proj.sprite.y,
+			Angle:    proj.sprite.angle,
+			Speed:    proj.sprite.speed,
+			Alpha:    proj.sprite.alpha,
+			Damage:   proj.damage,
+			Piercing: proj.piercing,
+		})
+	}
+
+	return save, nil
+}
+
+// ===========================================================
+// Load a saved game, the level is started fresh then the saved state applied
+// ===========================================================
+func (g *Game) loadGame(slot string) error {
+	data, err := ioutil.ReadFile(saveFilePath(slot))
+	if err != nil {
+		return fmt.Errorf("no saved game in slot '%s'", slot)
+	}
+
+	save := SaveFile{}
+	if err := json.Unmarshal(data, &save); err != nil {
+		return fmt.Errorf("saved game in slot '%s' is corrupt: %v", slot, err)
+	}
+	if save.Version > saveFileVersion {
+		return fmt.Errorf("saved game in slot '%s' has version %d, but this version of the game only supports up to version %d", slot, save.Version, saveFileVersion)
+	}
+
+	log.Printf("Loading game from slot '%s'", slot)
+	return g.restore(&save)
+}
+
+// ===========================================================
+// Upgrade a save from an older version of the game to the current format
+// ===========================================================
+func (save *SaveFile) upgrade() {
+	// Version 1 only had one shot switches, a pressed one is the same as a
+	// switch which is on
+	if save.Version <= 1 {
+		for _, cell := range save.Pressed {
+			save.Switches = append(save.Switches, SavedSwitch{X: cell[0], Y: cell[1], On: true})
+		}
+		save.Pressed = nil
+		save.Version = 2
+	}
+}
+
+// Start the saved level again then put everything back how it was
+func (g *Game) restore(save *SaveFile) (err error) {
+	// Version 1 has no random state, so the level carries on with its own
+	keepRandom := save.Version < 2
+	save.upgrade()
+
+	// Check everything first, so a bad save leaves the game being played alone.
+	// The level has to be started on the same difficulty, older saves were all normal
+	difficulty := defaultDifficulty
+	if save.Difficulty != "" {
+		if difficulty, err = parseDifficulty(save.Difficulty); err != nil {
+			return err
+		}
+	}
+	var campaign *Campaign
+	if save.Campaign != "" {
+		if campaign, err = readCampaign(save.Campaign); err != nil {
+			return err
+		}
+	}
+	if _, err := readMapFile(save.MapName); err != nil {
+		return fmt.Errorf("unable to load map '%s' for saved game: %v", save.MapName, err)
+	}
+
+	oldDifficulty := g.difficulty
+	g.difficulty = difficulty
+	g.start(save.MapName)
+	if g.state != GameStateMain {
+		g.difficulty = oldDifficulty
+		return fmt.Errorf("unable to load map '%s' for saved game", save.MapName)
+	}
+
+	g.campaign = campaign
+	if campaign != nil {
+		g.campaignLevel = save.CampaignLevel
+		g.campaignStats = save.CampaignStats.toStats()
+		g.campaignTime = save.CampaignStats.Elapsed
+	}
+
+	// Switches are set without doing their actions, the rest of the save has the results
+	for _, saved := range save.Switches {
+		sw := g.switchAt([2]int{saved.X, saved.Y})
+		if sw == nil {
+			continue
+		}
+		sw.on = saved.On
+		sw.offTick = saved.OffTick
+		sw.lastLight = saved.LastLight
+		if sw.on {
+			sw.wall.decoration = imageCache["decoration/switch-1"]
+		}
+		for _, cell := range saved.Created {
+			sw.restoreWall(g, cell)
+		}
+	}
+	if save.Light > 0 {
+		g.light = save.Light
+	}
+
+	for _, cell := range save.Removed {
+		if !g.inBounds(cell[0], cell[1]) {
+			continue
+		}
+		// Hang on to walls removed by switches, so they can be put back
+		for _, sw := range g.switches {
+			sw.holdRemoved(g, cell)
+		}
+		g.mapdata[cell[0]][cell[1]] = nil
+	}
+
+	// Globals are matched by name, so the script can change between saves
+	if g.script != nil && save.Script != nil {
+		g.script.started = save.Script.Started
+		g.script.failed = save.Script.Failed
+		for name, value := range save.Script.Globals {
+			if slot := g.script.globalSlot(name); slot >= 0 {
+				g.script.globals[slot] = value
+			}
+		}
+		for _, wall := range save.Script.Walls {
+			if g.inBounds(wall.X, wall.Y) && imageExists("walls/"+wall.Texture) {
+				g.mapdata[wall.X][wall.Y] = newWall(wall.X, wall.Y, wall.Texture)
+				g.script.walls[[2]int{wall.X, wall.Y}] = wall.Texture
+			}
+		}
+	}
+	for _, saved := range save.Doors {
+		if !g.inBounds(saved.X, saved.Y) || g.mapdata[saved.X][saved.Y] == nil || g.mapdata[saved.X][saved.Y].door == nil {
+			continue
+		}
+		door := g.mapdata[saved.X][saved.Y].door
+		door.state = saved.State
+		door.open = saved.Open
+		door.timer = saved.Timer
+		door.locked = saved.Locked
+		door.stayOpen = saved.StayOpen
+		door.held = saved.Held
+	}
+	for _, cell := range save.Seen {
+		if g.inBounds(cell[0], cell[1]) && g.mapdata[cell[0]][cell[1]] != nil {
+			g.mapdata[cell[0]][cell[1]].seen = true
+		}
+	}
+
+	// Remove any items which had been picked up
+	remaining := map[[2]int]bool{}
+	for _, cell := range save.Items {
+		remaining[cell] = true
+	}
+	for _, item := range g.itemList() {
+		if !remaining[[2]int{item.cellX, item.cellY}] {
+			delete(g.items, item.id)
+			g.removeSprite(item.sprite)
+		}
+	}
+	for _, item := range save.Dropped {
+		g.addDrop(item.Kind, item.X, item.Y)
+	}
+
+	// Replace all the monsters placed by the map with the saved ones
+	for _, mon := range g.monsterList() {
+		delete(g.monsters, mon.id)
+		g.removeSprite(mon.sprite)
+	}
+	restored := make([]*Monster, len(save.Monsters))
+	for i, saved := range save.Monsters {
+		mon := g.addMonster(saved.Kind, int(saved.X/cellSize), int(saved.Y/cellSize))
+		if mon == nil {
+			continue
+		}
+		restored[i] = mon
+		mon.sprite.x = saved.X
+		mon.sprite.y = saved.Y
+		mon.sprite.angle = saved.Angle
+		mon.sprite.speed = saved.Speed
+		mon.baseSpeed = saved.BaseSpeed
+		mon.health = saved.Health
+		mon.state = saved.State
+		mon.stateTicker = saved.StateTicker
+		mon.seenPlayer = saved.SeenPlayer
+		mon.lastSeenX = saved.LastSeenX
+		mon.lastSeenY = saved.LastSeenY
+		mon.patrol = saved.Patrol
+		mon.patrolIndex = saved.PatrolIndex
+		mon.goal = saved.Goal
+		mon.path = saved.Path
+		mon.pathTarget = saved.PathTarget
+	}
+
+	for _, saved := range save.Projectiles {
+		if proj := g.addProjectile(saved.Kind, saved.X, saved.Y, saved.Angle, saved.Speed, saved.Damage, saved.Alpha); proj != nil {
+			proj.piercing = saved.Piercing
+		}
+	}
+
+	g.player.x = save.Player.X
+	g.player.y = save.Player.Y
+	g.player.cellX = int(save.Player.X / cellSize)
+	g.player.cellY = int(save.Player.Y / cellSize)
+	g.player.angle = save.Player.Angle
+	g.player.health = save.Player.Health
+	g.player.mana = save.Player.Mana
+	if save.Player.Holding != nil {
+		g.player.holding = save.Player.Holding
+	}
+	if save.Player.Buffs != nil {
+		g.player.buffs = save.Player.Buffs
+	}
+	if weaponDefs[save.Player.Weapon] != nil {
+		g.player.weapon = save.Player.Weapon
+	}
+	g.player.lastAttack = save.Player.LastAttack
+	g.player.moveStartTick = save.Player.MoveStartTick
+	g.player.turnStartTick = save.Player.TurnStartTick
+	g.player.footstepTick = save.Player.FootstepTick
+	g.input = Input{held: save.Player.Actions}
+
+	// Triggers are matched up by position, so a changed map file might not line up
+	for i, t := range g.triggers {
+		t.inside = t.contains(g.player.cellX, g.player.cellY)
+		if i < len(save.Triggers) {
+			t.fired = save.Triggers[i].Fired
+			t.due = save.Triggers[i].Due
+			for _, index := range save.Triggers[i].Guards {
+				if index >= 0 && index < len(restored) && restored[index] != nil {
+					t.guards = append(t.guards, restored[index].id)
+				}
+			}
+		}
+	}
+	g.triggerCell = [2]int{g.player.cellX, g.player.cellY}
+
+	g.ticks = save.Ticks
+	g.stats = save.Stats.toStats()
+	g.stats.startTick = g.ticks - durationToTicks(save.Stats.Elapsed)
+	g.stats.endTick = -1
+
+	// Last of all, loading the level used up some random numbers
+	if !keepRandom {
+		g.seedRandom(save.Seed, save.Draws)
+	}
+	return nil
+}
+
+func newSavedStats(s Stats, elapsed time.Duration) SavedStats {
+	return SavedStats{
+		Monsters:     s.monsters,
+		Kills:        s.kills,
+		ItemsTotal:   s.itemsTotal,
+		ItemsFound:   s.itemsFound,
+		SecretsTotal: s.secretsTotal,
+		SecretsFound: s.secretsFound,
+		Score:        s.score,
+		DoorsOpened:  s.doorsOpened,
+		Elapsed:      elapsed,
+	}
+}
+
+func (s SavedStats) toStats() Stats {
+	return Stats{
+		monsters:     s.Monsters,
+		kills:        s.Kills,
+		itemsTotal:   s.ItemsTotal,
+		itemsFound:   s.ItemsFound,
+		secretsTotal: s.SecretsTotal,
+		secretsFound: s.SecretsFound,
+		score:        s.Score,
+		doorsOpened:  s.DoorsOpened,
+	}
+}
+
+// Find the slot with the most recent save, or empty string if there are none
+func latestSaveSlot() string {
+	latest := ""
+	var latestTime time.Time
+	files, _ := filepath.Glob(saveDir + "/*.json")
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest = strings.TrimSuffix(filepath.Base(file), ".json")
+			latestTime = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// Where all the monsters & projectiles are, to compare two games
+func (g *Game) snapshot() string {
+	s := g.summary()
+	for _, mon := range g.monsterList() {
+		s += fmt.Sprintf("\nmonster %s %.3f,%.3f %.3f %d %v", mon.def.name, mon.sprite.x, mon.sprite.y, mon.sprite.angle, mon.health, mon.state)
+	}
+	for _, proj := range g.projectileList() {
+		s += fmt.Sprintf("\nprojectile %.3f,%.3f", proj.sprite.x, proj.sprite.y)
+	}
+	return s
+}
+
+func TestLoadCarriesOnTheSame(t *testing.T) {
+	mapFile := testMap([]string{
+		"############",
+		"#P.........#",
+		"#..o....o..#",
+		"#.....o....#",
+		"#..o.......#",
+		"############",
+	})
+
+	// Play for a bit, save, then carry on playing
+	played := testGame(t, mapFile)
+	actions := func(tick int) uint32 {
+		if tick%90 < 30 {
+			return actionAttack | actionLeft
+		}
+		return actionAttack | actionForward
+	}
+	for i := 0; i < ticksPerSecond*2; i++ {
+		played.step(actions(played.ticks))
+	}
+	save, err := played.newSaveFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load into a new game, through JSON like the save file
+	data, err := json.Marshal(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := newGame(99, defaultDifficulty)
+	loaded.headless = true
+	save = &SaveFile{}
+	if err := json.Unmarshal(data, save); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.restore(save); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := played.snapshot(), loaded.snapshot(); a != b {
+		t.Fatalf("loaded game doesn't match the saved one:\n%s\n\n%s", a, b)
+	}
+
+	for i := 0; i < ticksPerSecond*3; i++ {
+		played.step(actions(played.ticks))
+		loaded.step(actions(loaded.ticks))
+	}
+	if a, b := played.snapshot(), loaded.snapshot(); a != b {
+		t.Errorf("loaded game went differently:\n%s\n\n%s", a, b)
+	}
+}
+
+func TestLoadVersion1(t *testing.T) {
+	mapFile := testMap([]string{
+		"######",
+		"#P.#.#",
+		"######",
+	}, &MapFileCell{X: 2, Y: 1, Type: "w", Value: "brick_gray_1", Extra: []string{"switch", "3", "1"}})
+	g := testGame(t, mapFile)
+	draws := g.rngSource.draws
+
+	// Switch pressed and its wall removed, saved before switches had state
+	save := &SaveFile{
+		Version: 1,
+		MapName: g.mapName,
+		Player:  g.player.saved(),
+		Removed: [][2]int{{3, 1}},
+		Pressed: [][2]int{{2, 1}},
+	}
+	if err := g.restore(save); err != nil {
+		t.Fatal(err)
+	}
+	if sw := g.switchAt([2]int{2, 1}); sw == nil || !sw.on {
+		t.Errorf("pressed switch should be on after loading")
+	}
+	if g.mapdata[3][1] != nil {
+		t.Errorf("wall removed by the switch is back")
+	}
+	if save.Version != saveFileVersion || save.Pressed != nil {
+		t.Errorf("save wasn't upgraded, version %d", save.Version)
+	}
+	if g.seed != 1 || g.rngSource.draws != draws {
+		t.Errorf("random numbers should carry on from the level, seed %d with %d draws", g.seed, g.rngSource.draws)
+	}
+}
+
+func TestBadSaveLeavesGameAlone(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"######",
+		"#P.o.#",
+		"######",
+	}))
+	g.difficulty = 0
+	g.run(ticksPerSecond, actionForward)
+	before := g.snapshot()
+
+	good, err := g.newSaveFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := []func(s *SaveFile){
+		func(s *SaveFile) { s.Difficulty = "impossible" },
+		func(s *SaveFile) { s.Campaign = "missing" },
+		func(s *SaveFile) { s.MapName = "missing" },
+	}
+	for _, change := range bad {
+		save := *good
+		change(&save)
+		if err := g.restore(&save); err == nil {
+			t.Errorf("bad save should fail to load")
+		}
+		if g.state != GameStateMain || g.difficulty != 0 || g.campaign != nil || g.snapshot() != before {
+			t.Errorf("failed load changed the game, state %v difficulty %d", g.state, g.difficulty)
+		}
+	}
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ===========================================================
+// A small scripting language for level logic. Scripts can only call the
+// functions in scriptBuiltins, so they can't touch the filesystem or
+// anything else outside the game, and they have no clock or randomness
+// of their own so they replay the same way every time
+// ===========================================================
+
+// Stops a script that's stuck in a loop from hanging the game
+const maxScriptSteps = 100000
+
+type Script struct {
+	name     string
+	funcs    map[string]*scriptFunc
+	globals  []interface{}
+	names    []string // Global names, in the same order as globals
+	inits    []*scriptNode
+	walls    map[[2]int]string // Walls put up by the script, with their textures
+	steps    int               // Steps used by the current call
+	started  bool              // onStart has been run
+	failed   bool              // Stopped after a runtime error
+	building bool              // Running the global initialisers
+}
+
+type scriptFunc struct {
+	name   string
+	params int
+	locals int
+	body   *scriptNode
+}
+
+// Expressions and statements are all nodes, kind says which
+type scriptNode struct {
+	kind  string
+	line  int
+	op    string
+	name  string
+	value interface{}
+	args  []*scriptNode // Call arguments, operands or the statements in a block
+	cond  *scriptNode
+	body  *scriptNode
+	other *scriptNode // Else branch
+	slot  int         // Local or global index once resolved
+}
+
+type scriptError struct {
+	line int
+	msg  string
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func scriptFail(line int, format string, args ...interface{}) {
+	panic(&scriptError{line, fmt.Sprintf(format, args...)})
+}
+
+// ===========================================================
+// Lexer
+// ===========================================================
+
+type scriptToken struct {
+	kind  string // ident, number, string, op, eof, or the keyword itself
+	text  string
+	value interface{}
+	line  int
+}
+
+// Strings can hold anything, so punctuation is only punctuation if it's an op
+func (t scriptToken) isOp(op string) bool {
+	return t.kind == "op" && t.text == op
+}
+
+var scriptKeywords = map[string]bool{
+	"var": true, "func": true, "if": true, "else": true, "while": true, "return": true,
+	"break": true, "continue": true, "true": true, "false": true, "nil": true,
+}
+
+func lexScript(src string) ([]scriptToken, error) {
+	tokens := []scriptToken{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, &scriptError{line, fmt.Sprintf("bad number '%s'", src[start:i])}
+			}
+			tokens = append(tokens, scriptToken{kind: "number", text: src[start:i], value: n, line: line})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			word := src[start:i]
+			kind := "ident"
+			if scriptKeywords[word] {
+				kind = word
+			}
+			tokens = append(tokens, scriptToken{kind: kind, text: word, line: line})
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\n' {
+					return nil, &scriptError{line, "string runs past the end of the line"}
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					default:
+						sb.WriteByte(src[i])
+					}
+				} else {
+					sb.WriteByte(src[i])
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, &scriptError{line, "string has no closing quote"}
+			}
+			i++
+			tokens = append(tokens, scriptToken{kind: "string", text: sb.String(), value: sb.String(), line: line})
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "=", "!", "(", ")", "{", "}", ",", ";"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &scriptError{line, fmt.Sprintf("unexpected character '%c'", c)}
+			}
+			tokens = append(tokens, scriptToken{kind: "op", text: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(tokens, scriptToken{kind: "eof", line: line}), nil
+}
+
+// ===========================================================
+// Parser, builds the nodes and then resolves every name so mistakes are
+// found when the map loads rather than half way through a level
+// ===========================================================
+
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+	script *Script
+	scopes []map[string]int // Local names to slots, innermost last
+	locals int
+	loops  int // How many loops deep we are, for break & continue
+}
+
+func compileScript(name, src string) (s *Script, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*scriptError)
+			if !ok {
+				panic(r)
+			}
+			s, err = nil, fmt.Errorf("script '%s' %v", name, se)
+		}
+	}()
+
+	tokens, lexErr := lexScript(src)
+	if lexErr != nil {
+		return nil, fmt.Errorf("script '%s' %v", name, lexErr)
+	}
+	p := &scriptParser{tokens: tokens, script: &Script{name: name, funcs: map[string]*scriptFunc{}, walls: map[[2]int]string{}}}
+
+	// Functions can call each other and use globals declared further down,
+	// so find everything declared at the top level before parsing properly
+	depth := 0
+	for i, tok := range tokens {
+		switch {
+		case tok.isOp("{"):
+			depth++
+		case tok.isOp("}"):
+			depth--
+		case depth == 0 && tok.kind == "var" && tokens[i+1].kind == "ident":
+			if p.script.globalSlot(tokens[i+1].text) >= 0 {
+				scriptFail(tok.line, "'%s' is already declared", tokens[i+1].text)
+			}
+			p.script.names = append(p.script.names, tokens[i+1].text)
+			p.script.globals = append(p.script.globals, nil)
+		case depth == 0 && tok.kind == "func" && tokens[i+1].kind == "ident":
+			fname := tokens[i+1].text
+			if p.script.funcs[fname] != nil || scriptBuiltins[fname] != nil {
+				scriptFail(tok.line, "function '%s' is already declared", fname)
+			}
+			params := 0
+			for j := i + 3; j < len(tokens) && !tokens[j].isOp(")") && tokens[j].kind != "eof"; j++ {
+				if tokens[j].kind == "ident" {
+					params++
+				}
+			}
+			p.script.funcs[fname] = &scriptFunc{name: fname, params: params}
+		}
+	}
+
+	for {
+		p.skipSemicolons()
+		tok := p.next()
+		switch tok.kind {
+		case "eof":
+			return p.script, nil
+
+		case "var":
+			nameTok := p.expect("ident")
+			if p.peek().isOp("=") {
+				p.next()
+				p.scopes, p.locals = nil, 0
+				p.script.inits = append(p.script.inits, &scriptNode{kind: "init", line: nameTok.line, slot: p.script.globalSlot(nameTok.text), body: p.expression()})
+			}
+
+		case "func":
+			nameTok := p.expect("ident")
+			f := p.script.funcs[nameTok.text]
+			if f == nil {
+				scriptFail(nameTok.line, "function '%s' can't be declared here", nameTok.text)
+			}
+			p.expectOp("(")
+			p.scopes, p.locals = []map[string]int{{}}, 0
+			for !p.peek().isOp(")") {
+				if p.locals > 0 {
+					p.expectOp(",")
+				}
+				param := p.expect("ident")
+				p.declare(param.text, param.line)
+			}
+			p.expectOp(")")
+			f.body = p.block()
+			f.locals = p.locals
+
+		default:
+			scriptFail(tok.line, "expected var or func at the top level, found '%s'", tok.text)
+		}
+	}
+}
+
+func (p *scriptParser) skipSemicolons() {
+	for p.peek().isOp(";") {
+		p.next()
+	}
+}
+
+func (p *scriptParser) peek() scriptToken {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() scriptToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *scriptParser) expect(kind string) scriptToken {
+	tok := p.next()
+	if tok.kind != kind {
+		if kind == "ident" {
+			kind = "a name"
+		}
+		scriptFail(tok.line, "expected %s, found '%s'", kind, tok.text)
+	}
+	return tok
+}
+
+func (p *scriptParser) expectOp(op string) {
+	tok := p.next()
+	if tok.kind != "op" || tok.text != op {
+		scriptFail(tok.line, "expected '%s', found '%s'", op, tok.text)
+	}
+}
+
+func (p *scriptParser) declare(name string, line int) int {
+	scope := p.scopes[len(p.scopes)-1]
+	if _, exists := scope[name]; exists {
+		scriptFail(line, "'%s' is already declared", name)
+	}
+	scope[name] = p.locals
+	p.locals++
+	return p.locals - 1
+}
+
+// Find a variable, locals first then globals
+func (p *scriptParser) lookup(name string, line int) (kind string, slot int) {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if slot, ok := p.scopes[i][name]; ok {
+			return "local", slot
+		}
+	}
+	if slot := p.script.globalSlot(name); slot >= 0 {
+		return "global", slot
+	}
+	scriptFail(line, "unknown variable '%s'", name)
+	return "", 0
+}
+
+func (s *Script) globalSlot(name string) int {
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *scriptParser) block() *scriptNode {
+	open := p.peek()
+	p.expectOp("{")
+	p.scopes = append(p.scopes, map[string]int{})
+	block := &scriptNode{kind: "block", line: open.line}
+	for p.skipSemicolons(); !p.peek().isOp("}"); p.skipSemicolons() {
+		if p.peek().kind == "eof" {
+			scriptFail(open.line, "block has no closing '}'")
+		}
+		block.args = append(block.args, p.statement())
+	}
+	p.next()
+	p.scopes = p.scopes[:len(p.scopes)-1]
+	return block
+}
+
+func (p *scriptParser) statement() *scriptNode {
+	tok := p.peek()
+	switch tok.kind {
+	case "var":
+		p.next()
+		name := p.expect("ident")
+		node := &scriptNode{kind: "assign", line: tok.line, op: "local"}
+		if p.peek().isOp("=") {
+			p.next()
+			node.cond = p.expression()
+		} else {
+			node.cond = &scriptNode{kind: "value", line: tok.line}
+		}
+		// Declared after the value so 'var x = x' uses the outer x
+		node.slot = p.declare(name.text, name.line)
+		return node
+
+	case "if":
+		p.next()
+		node := &scriptNode{kind: "if", line: tok.line, cond: p.expression(), body: p.block()}
+		if p.peek().kind == "else" {
+			p.next()
+			if p.peek().kind == "if" {
+				node.other = p.statement()
+			} else {
+				node.other = p.block()
+			}
+		}
+		return node
+
+	case "while":
+		p.next()
+		node := &scriptNode{kind: "while", line: tok.line, cond: p.expression()}
+		p.loops++
+		node.body = p.block()
+		p.loops--
+		return node
+
+	case "return":
+		p.next()
+		node := &scriptNode{kind: "return", line: tok.line}
+		if !p.peek().isOp("}") && !p.peek().isOp(";") && p.peek().line == tok.line {
+			node.cond = p.expression()
+		}
+		return node
+
+	case "break", "continue":
+		p.next()
+		if p.loops == 0 {
+			scriptFail(tok.line, "%s can only be used in a loop", tok.kind)
+		}
+		return &scriptNode{kind: tok.kind, line: tok.line}
+
+	case "ident":
+		if p.tokens[p.pos+1].isOp("=") {
+			p.next()
+			p.next()
+			kind, slot := p.lookup(tok.text, tok.line)
+			return &scriptNode{kind: "assign", line: tok.line, op: kind, slot: slot, cond: p.expression()}
+		}
+	}
+
+	// Only calls are allowed on their own, anything else is probably a mistake
+	expr := p.expression()
+	if expr.kind != "call" {
+		scriptFail(tok.line, "expected a statement, found '%s'", tok.text)
+	}
+	return expr
+}
+
+var scriptPrecedence = []map[string]bool{
+	{"||": true},
+	{"&&": true},
+	{"==": true, "!=": true},
+	{"<": true, "<=": true, ">": true, ">=": true},
+	{"+": true, "-": true},
+	{"*": true, "/": true, "%": true},
+}
+
+func (p *scriptParser) expression() *scriptNode {
+	return p.binary(0)
+}
+
+func (p *scriptParser) binary(level int) *scriptNode {
+	if level == len(scriptPrecedence) {
+		return p.unary()
+	}
+	left := p.binary(level + 1)
+	for {
+		tok := p.peek()
+		if tok.kind != "op" || !scriptPrecedence[level][tok.text] {
+			return left
+		}
+		p.next()
+		left = &scriptNode{kind: "binary", line: tok.line, op: tok.text, args: []*scriptNode{left, p.binary(level + 1)}}
+	}
+}
+
+func (p *scriptParser) unary() *scriptNode {
+	tok := p.peek()
+	if tok.kind == "op" && (tok.text == "-" || tok.text == "!") {
+		p.next()
+		return &scriptNode{kind: "unary", line: tok.line, op: tok.text, args: []*scriptNode{p.unary()}}
+	}
+	return p.primary()
+}
+
+func (p *scriptParser) primary() *scriptNode {
+	tok := p.next()
+	switch tok.kind {
+	case "number", "string":
+		return &scriptNode{kind: "value", line: tok.line, value: tok.value}
+	case "true", "false":
+		return &scriptNode{kind: "value", line: tok.line, value: tok.kind == "true"}
+	case "nil":
+		return &scriptNode{kind: "value", line: tok.line}
+	case "ident":
+		if !p.peek().isOp("(") {
+			kind, slot := p.lookup(tok.text, tok.line)
+			return &scriptNode{kind: kind, line: tok.line, name: tok.text, slot: slot}
+		}
+		p.next()
+		call := &scriptNode{kind: "call", line: tok.line, name: tok.text}
+		for !p.peek().isOp(")") {
+			if len(call.args) > 0 {
+				p.expectOp(",")
+			}
+			call.args = append(call.args, p.expression())
+		}
+		p.next()
+
+		params := -1
+		if f := p.script.funcs[tok.text]; f != nil {
+			params = f.params
+		} else if b := scriptBuiltins[tok.text]; b != nil {
+			params = b.params
+		} else {
+			scriptFail(tok.line, "unknown function '%s'", tok.text)
+		}
+		if len(call.args) != params {
+			scriptFail(tok.line, "%s takes %d arguments, not %d", tok.text, params, len(call.args))
+		}
+		return call
+	case "op":
+		if tok.text == "(" {
+			expr := p.expression()
+			p.expectOp(")")
+			return expr
+		}
+	}
+	scriptFail(tok.line, "expected a value, found '%s'", tok.text)
+	return nil
+}
+
+// Visit every node in the script, used by validate to check names
+func (s *Script) walk(visit func(n *scriptNode)) {
+	var walkNode func(n *scriptNode)
+	walkNode = func(n *scriptNode) {
+		if n == nil {
+			return
+		}
+		visit(n)
+		for _, arg := range n.args {
+			walkNode(arg)
+		}
+		walkNode(n.cond)
+		walkNode(n.body)
+		walkNode(n.other)
+	}
+	for _, init := range s.inits {
+		walkNode(init.body)
+	}
+	for _, f := range s.funcs {
+		walkNode(f.body)
+	}
+}
+
+// ===========================================================
+// Interpreter
+// ===========================================================
+
+type scriptFlow int
+
+const (
+	flowNext scriptFlow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+// Run the global initialisers, these happen once when the map loads
+func (s *Script) init(g *Game) error {
+	s.building = true
+	defer func() { s.building = false }()
+	for _, init := range s.inits {
+		var err error
+		s.globals[init.slot], err = s.protect(g, func() interface{} {
+			return s.eval(g, init.body, nil)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call a script function if it exists, errors stop the script for good
+func (s *Script) call(g *Game, name string, args ...interface{}) (interface{}, error) {
+	f := s.funcs[name]
+	if f == nil || s.failed {
+		return nil, nil
+	}
+	result, err := s.protect(g, func() interface{} {
+		return s.invoke(g, f, args, f.body.line)
+	})
+	if err != nil {
+		s.failed = true
+	}
+	return result, err
+}
+
+func (s *Script) protect(g *Game, run func() interface{}) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*scriptError)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, fmt.Errorf("script '%s' %v", s.name, se)
+		}
+	}()
+	s.steps = 0
+	return run(), nil
+}
+
+func (s *Script) invoke(g *Game, f *scriptFunc, args []interface{}, line int) interface{} {
+	if len(args) != f.params {
+		scriptFail(line, "%s takes %d arguments, not %d", f.name, f.params, len(args))
+	}
+	frame := make([]interface{}, f.locals)
+	copy(frame, args)
+	_, result := s.exec(g, f.body, frame)
+	return result
+}
+
+func (s *Script) step(line int) {
+	if s.steps++; s.steps > maxScriptSteps {
+		scriptFail(line, "took too long, is there a loop that never ends?")
+	}
+}
+
+func (s *Script) exec(g *Game, node *scriptNode, frame []interface{}) (scriptFlow, interface{}) {
+	s.step(node.line)
+	switch node.kind {
+	case "block":
+		for _, stmt := range node.args {
+			if flow, result := s.exec(g, stmt, frame); flow != flowNext {
+				return flow, result
+			}
+		}
+
+	case "assign":
+		value := s.eval(g, node.cond, frame)
+		if node.op == "global" {
+			s.globals[node.slot] = value
+		} else {
+			frame[node.slot] = value
+		}
+
+	case "if":
+		if scriptTruthy(s.eval(g, node.cond, frame)) {
+			return s.exec(g, node.body, frame)
+		} else if node.other != nil {
+			return s.exec(g, node.other, frame)
+		}
+
+	case "while":
+		for scriptTruthy(s.eval(g, node.cond, frame)) {
+			flow, result := s.exec(g, node.body, frame)
+			if flow == flowBreak {
+				break
+			}
+			if flow == flowReturn {
+				return flow, result
+			}
+		}
+
+	case "return":
+		if node.cond == nil {
+			return flowReturn, nil
+		}
+		return flowReturn, s.eval(g, node.cond, frame)
+
+	case "break":
+		return flowBreak, nil
+
+	case "continue":
+		return flowContinue, nil
+
+	default:
+		s.eval(g, node, frame)
+	}
+	return flowNext, nil
+}
+
+func (s *Script) eval(g *Game, node *scriptNode, frame []interface{}) interface{} {
+	s.step(node.line)
+	switch node.kind {
+	case "value":
+		return node.value
+
+	case "local":
+		return frame[node.slot]
+
+	case "global":
+		return s.globals[node.slot]
+
+	case "unary":
+		value := s.eval(g, node.args[0], frame)
+		if node.op == "!" {
+			return !scriptTruthy(value)
+		}
+		return -scriptNumber(node.line, value, "-")
+
+	case "binary":
+		// These two only look at the right hand side when they need to
+		if node.op == "&&" {
+			return scriptTruthy(s.eval(g, node.args[0], frame)) && scriptTruthy(s.eval(g, node.args[1], frame))
+		}
+		if node.op == "||" {
+			return scriptTruthy(s.eval(g, node.args[0], frame)) || scriptTruthy(s.eval(g, node.args[1], frame))
+		}
+		return scriptBinary(node.line, node.op, s.eval(g, node.args[0], frame), s.eval(g, node.args[1], frame))
+
+	case "call":
+		args := make([]interface{}, len(node.args))
+		for i, arg := range node.args {
+			args[i] = s.eval(g, arg, frame)
+		}
+		if f := s.funcs[node.name]; f != nil {
+			return s.invoke(g, f, args, node.line)
+		}
+		b := scriptBuiltins[node.name]
+		if s.building && !b.pure {
+			scriptFail(node.line, "%s can't be used until the level has started", node.name)
+		}
+		return b.fn(g, scriptArgs{line: node.line, name: node.name, values: args})
+	}
+	scriptFail(node.line, "can't run '%s'", node.kind)
+	return nil
+}
+
+func scriptBinary(line int, op string, a, b interface{}) interface{} {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "+":
+		// Adding to a string joins them together
+		as, aString := a.(string)
+		bs, bString := b.(string)
+		if aString || bString {
+			if !aString {
+				as = scriptString(a)
+			}
+			if !bString {
+				bs = scriptString(b)
+			}
+			return as + bs
+		}
+	}
+
+	x, y := scriptNumber(line, a, op), scriptNumber(line, b, op)
+	switch op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			scriptFail(line, "division by zero")
+		}
+		return x / y
+	case "%":
+		if y == 0 {
+			scriptFail(line, "division by zero")
+		}
+		return math.Mod(x, y)
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	scriptFail(line, "unknown operator '%s'", op)
+	return nil
+}
+
+// Only false and nil are false, like Lua
+func scriptTruthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return v != nil
+}
+
+func scriptNumber(line int, v interface{}, what string) float64 {
+	n, ok := v.(float64)
+	if !ok {
+		scriptFail(line, "%s needs a number, not %s", what, scriptString(v))
+	}
+	return n
+}
+
+func scriptString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
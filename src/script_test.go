@@ -0,0 +1,297 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLexScript(t *testing.T) {
+	tests := []struct {
+		src    string
+		tokens string // kind:text for each token, space separated
+	}{
+		{`var x = 1.5`, `var:var ident:x op:= number:1.5 eof:`},
+		{`a<=b&&!c`, `ident:a op:<= ident:b op:&& op:! ident:c eof:`},
+		{`f("a b", "{")`, `ident:f op:( string:a_b op:, string:{ op:) eof:`},
+		{`"say \"hi\"\n"`, "string:say_\"hi\"\n eof:"},
+		{"x // comment ) {\ny", `ident:x ident:y eof:`},
+		{`while true { break; }`, `while:while true:true op:{ break:break op:; op:} eof:`},
+	}
+
+	for _, test := range tests {
+		tokens, err := lexScript(test.src)
+		if err != nil {
+			t.Errorf("%s: %v", test.src, err)
+			continue
+		}
+		got := []string{}
+		for _, tok := range tokens {
+			got = append(got, tok.kind+":"+strings.ReplaceAll(tok.text, " ", "_"))
+		}
+		if strings.Join(got, " ") != test.tokens {
+			t.Errorf("%s: got %s, expected %s", test.src, strings.Join(got, " "), test.tokens)
+		}
+	}
+}
+
+func TestLexScriptLines(t *testing.T) {
+	tokens, err := lexScript("a\n// skip\n\nb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens[0].line != 1 || tokens[1].line != 4 {
+		t.Errorf("expected tokens on lines 1 & 4, got %d & %d", tokens[0].line, tokens[1].line)
+	}
+}
+
+func TestLexScriptErrors(t *testing.T) {
+	tests := map[string]string{
+		`"no end`:         "line 1: string has no closing quote",
+		"\"split\nline\"": "line 1: string runs past the end of the line",
+		"x\n@":            "line 2: unexpected character '@'",
+		"1.2.3":           "line 1: bad number '1.2.3'",
+	}
+	for src, expected := range tests {
+		if _, err := lexScript(src); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, got %v", src, expected, err)
+		}
+	}
+}
+
+func TestCompileScriptErrors(t *testing.T) {
+	tests := map[string]string{
+		`x = 1`:                              "line 1: expected var or func at the top level, found 'x'",
+		`func a() { b = 1 }`:                 "line 1: unknown variable 'b'",
+		`func a() { nope() }`:                "line 1: unknown function 'nope'",
+		`func a(x) {} func b() { a() }`:      "line 1: a takes 1 arguments, not 0",
+		`func a() { break }`:                 "line 1: break can only be used in a loop",
+		"var a\nvar a":                       "line 2: 'a' is already declared",
+		`func a() {} func a() {}`:            "line 1: function 'a' is already declared",
+		`func message(m) {}`:                 "line 1: function 'message' is already declared",
+		`func a() { 1 + 2 }`:                 "line 1: expected a statement, found '1'",
+		"func a() {\n  if x {}\n}":           "line 2: unknown variable 'x'",
+		`func a() { var x; var x }`:          "line 1: 'x' is already declared",
+		`func a() { return "{" } func b( {}`: "line 1: expected a name, found '{'",
+	}
+	for src, expected := range tests {
+		if _, err := compileScript("test", src); err == nil || err.Error() != "script 'test' "+expected {
+			t.Errorf("%q: expected error %q, got %v", src, expected, err)
+		}
+	}
+}
+
+// Run a function that doesn't use the game, returning what it returns
+func runTestScript(t *testing.T, src, name string, args ...interface{}) interface{} {
+	t.Helper()
+	s, err := compileScript("test", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.init(nil); err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.call(nil, name, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestScriptBracesInStrings(t *testing.T) {
+	// Braces & brackets in strings used to upset finding the functions
+	src := `
+var open = "{"
+func close() { return "}" }
+func paren() { return str(")") }
+func both() { return open + close() + paren() }
+`
+	if result := runTestScript(t, src, "both"); result != "{})" {
+		t.Errorf("expected {}), got %v", result)
+	}
+}
+
+func TestScriptRun(t *testing.T) {
+	src := `
+var calls = 0
+var greeting = "hello " + str(2)
+
+func fib(n) {
+  calls = calls + 1
+  if n < 2 { return n }
+  return fib(n - 1) + fib(n - 2)
+}
+
+func sumOdd(limit) {
+  var i = 0
+  var total = 0
+  while true {
+    i = i + 1
+    if i > limit { break }
+    if i % 2 == 0 { continue }
+    total = total + i
+  }
+  return total
+}
+
+func truthy(v) {
+  if v { return "yes" } else if v == false { return "false" }
+  return "nil"
+}
+
+func greet() { return greeting }
+func countCalls() { return calls }
+`
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"fib", []interface{}{10.0}, 55.0},
+		{"sumOdd", []interface{}{10.0}, 25.0},
+		{"truthy", []interface{}{0.0}, "yes"},
+		{"truthy", []interface{}{""}, "yes"},
+		{"truthy", []interface{}{false}, "false"},
+		{"truthy", []interface{}{nil}, "nil"},
+		{"greet", nil, "hello 2"},
+		{"countCalls", nil, 0.0},
+	}
+	for _, test := range tests {
+		if result := runTestScript(t, src, test.name, test.args...); result != test.expected {
+			t.Errorf("%s%v returned %v, expected %v", test.name, test.args, result, test.expected)
+		}
+	}
+}
+
+func TestScriptRuntimeErrors(t *testing.T) {
+	tests := map[string]string{
+		`func a() { return 1 / 0 }`:          "line 1: division by zero",
+		`func a() { return "x" - 1 }`:        "line 1: - needs a number, not x",
+		`func a() { while true {} }`:         "line 1: took too long, is there a loop that never ends?",
+		`func a() { return floor("x") }`:     "line 1: floor needs a number, not x",
+		"func a() {\n  return a()\n}":        "line 2: took too long, is there a loop that never ends?",
+		`var x = message("hi") func a() { }`: "line 1: message can't be used until the level has started",
+	}
+	for src, expected := range tests {
+		s, err := compileScript("test", src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if err = s.init(nil); err == nil {
+			_, err = s.call(nil, "a")
+		}
+		if err == nil || err.Error() != "script 'test' "+expected {
+			t.Errorf("%q: expected error %q, got %v", src, expected, err)
+		}
+	}
+}
+
+func TestScriptStopsAfterError(t *testing.T) {
+	s, err := compileScript("test", `func a() { return 1 / 0 } func b() { return 1 }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.call(nil, "a"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if result, err := s.call(nil, "b"); result != nil || err != nil || !s.failed {
+		t.Errorf("script should be stopped after an error, got %v, %v", result, err)
+	}
+}
+
+// Start a headless game on a test map with a script
+func testScriptGame(t *testing.T, mapFile *MapFile, src string) *Game {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.script")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mapFile.Script = path
+	return testGame(t, mapFile)
+}
+
+func TestScriptInGame(t *testing.T) {
+	mapFile := testMap([]string{
+		"######",
+		"#PX..#",
+		"#...o#",
+		"######",
+	})
+	g := testScriptGame(t, mapFile, `
+var used = 0
+
+func onStart() {
+  message("Started")
+  setWall(3, 2, "brick_gray_1")
+}
+
+// Keeps the exit shut
+func onUse(x, y) {
+  used = used + 1
+  return x == 2 && y == 1
+}
+
+func onKill(kind, x, y) {
+  message(kind + " killed at " + x + "," + y)
+  setWall(3, 2, "")
+  setWall(2, 2, "brick_gray_1")
+}
+`)
+
+	g.step(0)
+	if g.message != "Started" || g.mapdata[3][2] == nil {
+		t.Fatalf("onStart should have shown a message and put up a wall, message '%s'", g.message)
+	}
+
+	tap(g, actionUse)
+	if g.state != GameStateMain {
+		t.Fatal("onUse returning true should stop the exit ending the level")
+	}
+	if used := g.script.globals[g.script.globalSlot("used")]; used != 1.0 {
+		t.Errorf("used should be 1, got %v", used)
+	}
+
+	g.monsterList()[0].kill(g)
+	if g.message != "orc killed at 4,2" || g.mapdata[3][2] != nil || g.mapdata[2][2] == nil {
+		t.Errorf("onKill should have moved the wall, message '%s'", g.message)
+	}
+
+	// Globals & walls put up by the script are saved with the game
+	save, err := g.newSaveFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := newGame(1, defaultDifficulty)
+	loaded.headless = true
+	if err := loaded.restore(save); err != nil {
+		t.Fatal(err)
+	}
+	if used := loaded.script.globals[loaded.script.globalSlot("used")]; used != 1.0 {
+		t.Errorf("used should be 1 after loading, got %v", used)
+	}
+	if loaded.mapdata[3][2] != nil || loaded.mapdata[2][2] == nil {
+		t.Error("walls changed by the script should be the same after loading")
+	}
+	if !loaded.script.started {
+		t.Error("onStart shouldn't run again after loading")
+	}
+}
+
+func TestScriptTeleportFacing(t *testing.T) {
+	g := testScriptGame(t, testMap([]string{
+		"#####",
+		"#P..#",
+		"#####",
+	}), `
+func onStart() {
+  teleport(3, 1, 7)
+}
+`)
+	angle := g.player.angle
+	g.step(0)
+	if !g.script.failed || g.player.angle != angle {
+		t.Errorf("teleport with facing 7 should stop the script, player angle %.2f", g.player.angle)
+	}
+}
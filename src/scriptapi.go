@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strings"
+)
+
+const scriptDir = "./maps/scripts"
+
+// Functions the game calls in a level's script, with how many arguments they take
+var scriptHooks = map[string]int{
+	"onStart":  0, // First tick of the level
+	"onUse":    2, // Player used the wall at x, y, return true to stop the wall's own action
+	"onKill":   3, // Monster kind killed at x, y
+	"onPickup": 3, // Item kind picked up at x, y
+	"onTick":   1, // Every tick, with the tick count
+}
+
+type scriptBuiltin struct {
+	params int
+	pure   bool // Doesn't touch the game, so can be used to set up globals
+	fn     func(g *Game, a scriptArgs) interface{}
+}
+
+type scriptArgs struct {
+	line   int
+	name   string
+	values []interface{}
+}
+
+func (a scriptArgs) number(i int) float64 {
+	return scriptNumber(a.line, a.values[i], a.name)
+}
+
+func (a scriptArgs) int(i int) int {
+	return int(math.Floor(a.number(i)))
+}
+
+func (a scriptArgs) string(i int) string {
+	s, ok := a.values[i].(string)
+	if !ok {
+		scriptFail(a.line, "%s needs a string, not %s", a.name, scriptString(a.values[i]))
+	}
+	return s
+}
+
+// ===========================================================
+// Everything a script can do, this is the only way it can reach the game
+// ===========================================================
+var scriptBuiltins = map[string]*scriptBuiltin{
+	"print": {1, true, func(g *Game, a scriptArgs) interface{} {
+		log.Printf("Script: %s", scriptString(a.values[0]))
+		return nil
+	}},
+	"str": {1, true, func(g *Game, a scriptArgs) interface{} {
+		return scriptString(a.values[0])
+	}},
+	"floor": {1, true, func(g *Game, a scriptArgs) interface{} {
+		return math.Floor(a.number(0))
+	}},
+	"random": {1, false, func(g *Game, a scriptArgs) interface{} {
+		if n := a.int(0); n > 0 {
+			return float64(g.rng.Intn(n))
+		}
+		return 0.0
+	}},
+
+	"message": {1, false, func(g *Game, a scriptArgs) interface{} {
+		g.showMessage(scriptString(a.values[0]))
+		return nil
+	}},
+	"sound": {1, false, func(g *Game, a scriptArgs) interface{} {
+		playSound(a.string(0), 1.0, false)
+		return nil
+	}},
+
+	"spawn": {3, false, func(g *Game, a scriptArgs) interface{} {
+		x, y := a.int(1), a.int(2)
+		if !g.inBounds(x, y) || g.mapdata[x][y] != nil || g.cellOccupied(x, y) {
+			return false
+		}
+		mon := g.addMonster(a.string(0), x, y)
+		if mon == nil {
+			return false
+		}
+		mon.alert(g.player.x, g.player.y)
+		return true
+	}},
+	"isWall": {2, false, func(g *Game, a scriptArgs) interface{} {
+		x, y := a.int(0), a.int(1)
+		return !g.inBounds(x, y) || g.mapdata[x][y] != nil
+	}},
+	"setWall": {3, false, func(g *Game, a scriptArgs) interface{} {
+		return g.scriptSetWall(a.int(0), a.int(1), a.string(2))
+	}},
+	"openDoor": {2, false, func(g *Game, a scriptArgs) interface{} {
+		if door := g.doorAt(a.int(0), a.int(1)); door != nil {
+			door.holdOpen(g)
+			return true
+		}
+		return false
+	}},
+	"closeDoor": {2, false, func(g *Game, a scriptArgs) interface{} {
+		if door := g.doorAt(a.int(0), a.int(1)); door != nil {
+			door.startClosing()
+			return true
+		}
+		return false
+	}},
+	"teleport": {3, false, func(g *Game, a scriptArgs) interface{} {
+		// Facing is 0 ~ 3 like the player start, or -1 to keep facing the same way
+		facing := a.int(2)
+		if facing < -1 || facing > 3 {
+			scriptFail(a.line, "teleport facing should be -1 to 3, not %d", facing)
+		}
+		g.teleportPlayer([2]int{a.int(0), a.int(1)}, facing)
+		return nil
+	}},
+	"endLevel": {0, false, func(g *Game, a scriptArgs) interface{} {
+		g.endLevel()
+		return nil
+	}},
+
+	"playerX": {0, false, func(g *Game, a scriptArgs) interface{} {
+		return float64(g.player.cellX)
+	}},
+	"playerY": {0, false, func(g *Game, a scriptArgs) interface{} {
+		return float64(g.player.cellY)
+	}},
+	"health": {0, false, func(g *Game, a scriptArgs) interface{} {
+		return float64(g.player.health)
+	}},
+	"setHealth": {1, false, func(g *Game, a scriptArgs) interface{} {
+		if n := a.int(0); n > 0 {
+			g.player.health = n
+		} else {
+			g.player.damage(g, g.player.health)
+		}
+		return nil
+	}},
+	"mana": {0, false, func(g *Game, a scriptArgs) interface{} {
+		return float64(g.player.mana)
+	}},
+	"setMana": {1, false, func(g *Game, a scriptArgs) interface{} {
+		g.player.mana = int(math.Max(0, a.number(0)))
+		return nil
+	}},
+	"holding": {1, false, func(g *Game, a scriptArgs) interface{} {
+		return float64(g.player.holding[a.string(0)])
+	}},
+	"give": {1, false, func(g *Game, a scriptArgs) interface{} {
+		def := itemDefs[a.string(0)]
+		if def == nil || def.Effect == effectFurniture {
+			return false
+		}
+		def.pickUp(g)
+		return true
+	}},
+	"stat": {1, false, func(g *Game, a scriptArgs) interface{} {
+		switch name := a.string(0); name {
+		case "kills":
+			return float64(g.stats.kills)
+		case "monsters":
+			return float64(g.stats.monsters)
+		case "items":
+			return float64(g.stats.itemsFound)
+		case "secrets":
+			return float64(g.stats.secretsFound)
+		case "score":
+			return float64(g.stats.score)
+		case "ticks":
+			return float64(g.ticks)
+		default:
+			scriptFail(a.line, "unknown stat '%s'", name)
+		}
+		return nil
+	}},
+}
+
+// Scripts are named after their file in the scripts folder, but like maps
+// a path to any .script file works too
+func scriptPath(name string) string {
+	if strings.HasSuffix(name, ".script") {
+		return name
+	}
+	return scriptDir + "/" + name + ".script"
+}
+
+// ===========================================================
+// Load and compile the script for a map, hooks must take the right arguments
+// ===========================================================
+func loadScript(name string) (*Script, error) {
+	src, err := ioutil.ReadFile(scriptPath(name))
+	if err != nil {
+		return nil, err
+	}
+	s, err := compileScript(name, string(src))
+	if err != nil {
+		return nil, err
+	}
+	for hook, params := range scriptHooks {
+		if f := s.funcs[hook]; f != nil && f.params != params {
+			return nil, fmt.Errorf("script '%s' %s should take %d arguments, not %d", name, hook, params, f.params)
+		}
+	}
+	return s, nil
+}
+
+// Call a function in the level's script, if there is one. Errors are logged
+// and stop the script, rather than the whole game
+func (g *Game) runScript(name string, args ...interface{}) interface{} {
+	if g.script == nil || g.state != GameStateMain {
+		return nil
+	}
+	result, err := g.script.call(g, name, args...)
+	if err != nil {
+		log.Printf("ERROR! %v, the script has been stopped", err)
+	}
+	return result
+}
+
+func (g *Game) doorAt(x, y int) *Door {
+	if !g.inBounds(x, y) || g.mapdata[x][y] == nil {
+		return nil
+	}
+	return g.mapdata[x][y].door
+}
+
+// Put up a wall, or take one down when the texture is blank. Doors, switches
+// & furniture are left alone, and walls never go on top of anything
+func (g *Game) scriptSetWall(x, y int, texture string) bool {
+	if !g.inBounds(x, y) {
+		return false
+	}
+	cell := [2]int{x, y}
+	wall := g.mapdata[x][y]
+
+	if texture == "" {
+		if wall == nil || wall.door != nil || wall.sw != nil || wall.invisible {
+			return false
+		}
+		g.mapdata[x][y] = nil
+		delete(g.script.walls, cell)
+		return true
+	}
+
+	if wall != nil || g.cellOccupied(x, y) || !imageExists("walls/"+texture) {
+		return false
+	}
+	g.mapdata[x][y] = newWall(x, y, texture)
+	g.script.walls[cell] = texture
+	return true
+}
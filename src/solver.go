@@ -0,0 +1,510 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A door, switch or secret wall that had to be opened to reach more of the level
+type Gate struct {
+	Kind  string // e.g. "door", "key_red door", "switch", "secret"
+	X     int    // Cell of the door, switch or secret wall
+	Y     int
+	Key   string // Key used to open the gate, if any
+	Opens int    // Number of cells that became reachable
+}
+
+// Results of analysing a level with solveMapFile
+type LevelReport struct {
+	ExitReachable       bool
+	Gates               []Gate   // In the order they were opened
+	Keys                []string // Keys collected, in the order they were found
+	UnreachableItems    [][2]int
+	UnreachableMonsters [][2]int
+	InaccessibleSecrets [][2]int
+}
+
+// ===========================================================
+// The solve subcommand, reports on one or all maps and returns an
+// exit code which is non-zero if any level can't be completed
+// ===========================================================
+func runSolve(args []string) int {
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	difficultyName := flags.String("difficulty", "", "Only check this difficulty: easy, normal, hard or nightmare (default all of them)")
+	_ = flags.Parse(args)
+
+	names := flags.Args()
+	if len(names) == 0 {
+		names = listMaps()
+	}
+
+	levels := []string{}
+	for _, d := range difficulties {
+		levels = append(levels, d.name)
+	}
+	if *difficultyName != "" {
+		difficulty, err := parseDifficulty(*difficultyName)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		levels = []string{difficulties[difficulty].name}
+	}
+
+	// Needed to know which items are keys & furniture
+	if err := loadDefinitions(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, name := range names {
+		name = strings.TrimSuffix(filepath.Base(name), ".json")
+
+		for _, difficulty := range levels {
+			report, err := solveLevel(name, difficulty)
+			if err != nil {
+				fmt.Printf("%s: %v\n", name, err)
+				exitCode = 1
+				break
+			}
+
+			status := "completable"
+			if !report.ExitReachable {
+				status = "NOT completable, the exit can't be reached"
+				exitCode = 1
+			}
+			fmt.Printf("%s (%s): %s\n", name, difficulty, status)
+
+			for _, gate := range report.Gates {
+				fmt.Printf("  - %s at %d,%d opens %d cells\n", gate.Kind, gate.X, gate.Y, gate.Opens)
+			}
+			for _, cell := range report.UnreachableItems {
+				fmt.Printf("  - unreachable item at %d,%d\n", cell[0], cell[1])
+			}
+			for _, cell := range report.UnreachableMonsters {
+				fmt.Printf("  - unreachable monster at %d,%d\n", cell[0], cell[1])
+			}
+			for _, cell := range report.InaccessibleSecrets {
+				fmt.Printf("  - inaccessible secret at %d,%d\n", cell[0], cell[1])
+			}
+		}
+	}
+
+	return exitCode
+}
+
+// Load and analyse a map by name, on one difficulty
+func solveLevel(name string, difficulty string) (*LevelReport, error) {
+	mapFile, err := readMapFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return solveMapFile(mapFile, difficulty)
+}
+
+// ===========================================================
+// Work out how much of a level the player can reach, by flood filling from
+// the start and opening doors, secrets & switches as they are found. Only
+// cells present on the difficulty are counted. Each key only opens one door,
+// so every way of spending them is tried until the exit is reached
+// ===========================================================
+func solveMapFile(mapFile *MapFile, difficulty string) (*LevelReport, error) {
+	sv := &solver{
+		mapFile:     mapFile,
+		grid:        mapFile.gridOn(difficulty),
+		tried:       map[string]bool{},
+		everReached: map[[2]int]bool{},
+		everOpened:  map[[2]int]bool{},
+	}
+
+	var start *MapFileCell
+	for x := range sv.grid {
+		for y := range sv.grid[x] {
+			if sv.grid[x][y] != nil && sv.grid[x][y].Type == "p" {
+				start = sv.grid[x][y]
+			}
+		}
+	}
+	if start == nil {
+		return nil, fmt.Errorf("map has no player start")
+	}
+	for i, mt := range mapFile.Triggers {
+		t, err := parseTrigger(mt)
+		if err != nil {
+			return nil, fmt.Errorf("trigger %d: %v", i+1, err)
+		}
+		sv.triggers = append(sv.triggers, t)
+	}
+
+	s := &solveState{
+		opened:    map[[2]int]bool{},
+		pressed:   map[[2]int]bool{},
+		reachable: map[[2]int]bool{},
+		collected: map[[2]int]bool{},
+		keys:      map[string]int{},
+		sprung:    map[int]bool{},
+		report:    &LevelReport{},
+	}
+	sv.flood(s, start.X, start.Y)
+
+	best, err := sv.search(s)
+	if err != nil {
+		return nil, err
+	}
+	report := best.report
+
+	// Anything not reached by any way of playing the level can't be reached
+	grid := sv.grid
+	for x := 0; x < mapFile.Width; x++ {
+		for y := 0; y < mapFile.Height; y++ {
+			cell := grid[x][y]
+			pos := [2]int{x, y}
+			if cell == nil {
+				continue
+			}
+			if cell.Type == "i" && !isFurniture(cell.Value) && !sv.everReached[pos] {
+				report.UnreachableItems = append(report.UnreachableItems, pos)
+			}
+			if cell.Type == "m" && !sv.everReached[pos] {
+				report.UnreachableMonsters = append(report.UnreachableMonsters, pos)
+			}
+			if cell.Type == "w" && len(cell.Extra) > 0 && cell.Extra[0] == "secret" && !sv.everOpened[pos] {
+				report.InaccessibleSecrets = append(report.InaccessibleSecrets, pos)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// The level being solved, and what's been found over all the ways of playing it
+type solver struct {
+	mapFile  *MapFile
+	grid     [][]*MapFileCell
+	triggers []*Trigger
+
+	tried       map[string]bool // Sets of key doors already opened together
+	everReached map[[2]int]bool
+	everOpened  map[[2]int]bool
+}
+
+// How far the player has got one way of playing, copied to try each way of spending keys
+type solveState struct {
+	opened    map[[2]int]bool // Walls & doors that have been removed
+	pressed   map[[2]int]bool // Switches & teleporters that have been used
+	reachable map[[2]int]bool
+	collected map[[2]int]bool // Keys that have been picked up
+	keys      map[string]int
+	sprung    map[int]bool // Triggers that have been set off
+	keyDoors  []string     // Doors opened with keys, to spot the same set being tried twice
+	report    *LevelReport
+}
+
+func (s *solveState) copy() *solveState {
+	copyCells := func(m map[[2]int]bool) map[[2]int]bool {
+		c := map[[2]int]bool{}
+		for k, v := range m {
+			c[k] = v
+		}
+		return c
+	}
+	c := &solveState{
+		opened:    copyCells(s.opened),
+		pressed:   copyCells(s.pressed),
+		reachable: copyCells(s.reachable),
+		collected: copyCells(s.collected),
+		keys:      map[string]int{},
+		sprung:    map[int]bool{},
+		keyDoors:  append([]string{}, s.keyDoors...),
+		report:    &LevelReport{ExitReachable: s.report.ExitReachable},
+	}
+	for k, v := range s.keys {
+		c.keys[k] = v
+	}
+	for k, v := range s.sprung {
+		c.sprung[k] = v
+	}
+	c.report.Gates = append(c.report.Gates, s.report.Gates...)
+	c.report.Keys = append(c.report.Keys, s.report.Keys...)
+	return c
+}
+
+func (sv *solver) cellAt(pos [2]int) *MapFileCell {
+	if pos[0] < 0 || pos[1] < 0 || pos[0] >= sv.mapFile.Width || pos[1] >= sv.mapFile.Height {
+		return nil
+	}
+	return sv.grid[pos[0]][pos[1]]
+}
+
+func (sv *solver) blocking(s *solveState, x, y int) bool {
+	if x < 0 || y < 0 || x >= sv.mapFile.Width || y >= sv.mapFile.Height {
+		return true
+	}
+	cell := sv.grid[x][y]
+	if cell == nil || s.opened[[2]int{x, y}] {
+		return false
+	}
+	return cell.Type == "w" || cell.Type == "d" || (cell.Type == "i" && isFurniture(cell.Value))
+}
+
+// Flood fill from a cell, returns the number of new cells reached
+func (sv *solver) flood(s *solveState, x, y int) int {
+	count := 0
+	queue := [][2]int{{x, y}}
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		if s.reachable[pos] || sv.blocking(s, pos[0], pos[1]) {
+			continue
+		}
+		s.reachable[pos] = true
+		sv.everReached[pos] = true
+		count++
+		// Teleporter pads can't be walked across, unless the player arrived on one
+		if cell := sv.grid[pos[0]][pos[1]]; cell != nil && cell.Type == "t" && pos != [2]int{x, y} {
+			continue
+		}
+		queue = append(queue, [2]int{pos[0] + 1, pos[1]}, [2]int{pos[0] - 1, pos[1]}, [2]int{pos[0], pos[1] + 1}, [2]int{pos[0], pos[1] - 1})
+	}
+	return count
+}
+
+// A blocking cell can be used by the player if they can stand next to it
+func (s *solveState) usable(x, y int) bool {
+	return s.reachable[[2]int{x + 1, y}] || s.reachable[[2]int{x - 1, y}] || s.reachable[[2]int{x, y + 1}] || s.reachable[[2]int{x, y - 1}]
+}
+
+// Open a gate and record how much of the level it leads to
+func (sv *solver) open(s *solveState, kind string, x, y int, key string, target [2]int) {
+	s.opened[target] = true
+	sv.everOpened[target] = true
+	opens := 0
+	if s.usable(target[0], target[1]) || s.reachable[target] {
+		opens = sv.flood(s, target[0], target[1])
+	}
+	s.report.Gates = append(s.report.Gates, Gate{Kind: kind, X: x, Y: y, Key: key, Opens: opens})
+}
+
+// A trigger can be set off once the player can get into its area, or get
+// to the item it's waiting for
+func (sv *solver) triggerReachable(s *solveState, t *Trigger) bool {
+	for x := range sv.grid {
+		for y := range sv.grid[x] {
+			if !s.reachable[[2]int{x, y}] {
+				continue
+			}
+			if t.on != triggerPickup && t.contains(x, y) {
+				return true
+			}
+			cell := sv.grid[x][y]
+			if t.on == triggerPickup && cell != nil && cell.Type == "i" && (t.item == "" || t.item == cell.Value) && (!t.hasArea || t.contains(x, y)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// One way doors can only be opened from one side
+func (sv *solver) doorUsable(s *solveState, x, y int) (bool, error) {
+	oneWay, _, err := parseDoorFlags(sv.grid[x][y].Extra)
+	if err != nil {
+		return false, fmt.Errorf("door at %d,%d: %v", x, y, err)
+	}
+	return oneWay == "" || s.reachable[sideCell(x, y, oneWay)], nil
+}
+
+// ===========================================================
+// Open everything that doesn't need a key, then try each key door the
+// player could spend a key on. Returns the first way of playing that
+// reaches the exit, or the one that gets furthest if none do
+// ===========================================================
+func (sv *solver) search(s *solveState) (*solveState, error) {
+	if err := sv.openFree(s); err != nil {
+		return nil, err
+	}
+	if s.report.ExitReachable {
+		return s, nil
+	}
+
+	best := s
+	for x := 0; x < sv.mapFile.Width; x++ {
+		for y := 0; y < sv.mapFile.Height; y++ {
+			cell := sv.grid[x][y]
+			pos := [2]int{x, y}
+			if cell == nil || cell.Type != "d" || !strings.HasPrefix(cell.Value, "key") || s.opened[pos] || s.keys[cell.Value] <= 0 || !s.usable(x, y) {
+				continue
+			}
+			ok, err := sv.doorUsable(s, x, y)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			// Opening the same doors in a different order ends up in the same place
+			keyDoors := append(append([]string{}, s.keyDoors...), fmt.Sprintf("%d,%d", x, y))
+			sort.Strings(keyDoors)
+			tried := strings.Join(keyDoors, " ")
+			if sv.tried[tried] {
+				continue
+			}
+			sv.tried[tried] = true
+
+			next := s.copy()
+			next.keyDoors = keyDoors
+			next.keys[cell.Value]--
+			sv.open(next, cell.Value+" door", x, y, cell.Value, pos)
+			result, err := sv.search(next)
+			if err != nil {
+				return nil, err
+			}
+			if result.report.ExitReachable {
+				return result, nil
+			}
+			if len(result.reachable) > len(best.reachable) {
+				best = result
+			}
+		}
+	}
+	return best, nil
+}
+
+// Pick up keys, set off triggers and open anything that doesn't need a key, until there's nothing left
+func (sv *solver) openFree(s *solveState) error {
+	grid := sv.grid
+	for {
+		// Pick up any keys we can reach
+		for x := range grid {
+			for y := range grid[x] {
+				cell := grid[x][y]
+				pos := [2]int{x, y}
+				if cell == nil || cell.Type != "i" || !isKey(cell.Value) || !s.reachable[pos] || s.collected[pos] {
+					continue
+				}
+				s.collected[pos] = true
+				s.keys[cell.Value]++
+				s.report.Keys = append(s.report.Keys, cell.Value)
+			}
+		}
+
+		progress := false
+
+		// Triggers the player can set off might open doors, teleport or end the level
+		for i, t := range sv.triggers {
+			if s.sprung[i] || !sv.triggerReachable(s, t) {
+				continue
+			}
+			s.sprung[i] = true
+			progress = true
+
+			for _, action := range t.actions {
+				switch action[0] {
+				case "open":
+					for _, value := range action[1:] {
+						if cell, ok := parseCell(value); ok && sv.cellAt(cell) != nil && !s.opened[cell] {
+							sv.open(s, "trigger", t.area[0], t.area[1], "", cell)
+						}
+					}
+				case "teleport":
+					if cell, ok := parseCell(action[1]); ok && !sv.blocking(s, cell[0], cell[1]) {
+						s.report.Gates = append(s.report.Gates, Gate{Kind: "teleport", X: t.area[0], Y: t.area[1], Opens: sv.flood(s, cell[0], cell[1])})
+					}
+				case "endlevel":
+					s.report.ExitReachable = true
+				}
+			}
+		}
+		// Teleporter pads the player can step onto
+		for x := range grid {
+			for y := range grid[x] {
+				cell := grid[x][y]
+				pos := [2]int{x, y}
+				if cell == nil || cell.Type != "t" || !s.reachable[pos] || s.pressed[pos] {
+					continue
+				}
+				s.pressed[pos] = true
+				t, err := parseTeleporter(append([]string{cell.Value}, cell.Extra...), true)
+				if err != nil {
+					return fmt.Errorf("teleporter at %d,%d: %v", x, y, err)
+				}
+				s.report.Gates = append(s.report.Gates, Gate{Kind: "teleport", X: x, Y: y, Opens: sv.flood(s, t.dest[0], t.dest[1])})
+				progress = true
+			}
+		}
+		for x := 0; x < sv.mapFile.Width && !progress; x++ {
+			for y := 0; y < sv.mapFile.Height && !progress; y++ {
+				cell := grid[x][y]
+				pos := [2]int{x, y}
+				if cell == nil || s.opened[pos] || !sv.blocking(s, x, y) || !s.usable(x, y) {
+					continue
+				}
+
+				if cell.Type == "w" && len(cell.Extra) > 0 {
+					switch cell.Extra[0] {
+					case "exit":
+						s.report.ExitReachable = true
+					case "secret":
+						sv.open(s, "secret", x, y, "", pos)
+						progress = true
+					case "switch":
+						if s.pressed[pos] {
+							continue
+						}
+						s.pressed[pos] = true
+						sw, err := parseSwitch(cell.Extra)
+						if err != nil {
+							return fmt.Errorf("switch at %d,%d: %v", x, y, err)
+						}
+						// Linked switches are flipped too, so whatever they open counts
+						targets := sw.opens()
+						for _, link := range sw.links {
+							if linked := sv.cellAt(link); linked != nil && len(linked.Extra) > 0 && linked.Extra[0] == "switch" && !s.pressed[link] {
+								if other, err := parseSwitch(linked.Extra); err == nil {
+									targets = append(targets, other.opens()...)
+								}
+							}
+						}
+						for _, target := range targets {
+							if sv.cellAt(target) != nil && !s.opened[target] {
+								sv.open(s, "switch", x, y, "", target)
+							}
+						}
+						progress = true
+					case "teleport":
+						if s.pressed[pos] {
+							continue
+						}
+						s.pressed[pos] = true
+						t, err := parseTeleporter(cell.Extra[1:], false)
+						if err != nil {
+							return fmt.Errorf("teleporter at %d,%d: %v", x, y, err)
+						}
+						s.report.Gates = append(s.report.Gates, Gate{Kind: "teleport", X: x, Y: y, Opens: sv.flood(s, t.dest[0], t.dest[1])})
+						progress = true
+					}
+				}
+
+				if cell.Type == "d" && cell.Value == "basic" {
+					ok, err := sv.doorUsable(s, x, y)
+					if err != nil {
+						return err
+					}
+					if ok {
+						sv.open(s, "door", x, y, "", pos)
+						progress = true
+					}
+				}
+			}
+		}
+
+		if !progress {
+			return nil
+		}
+	}
+}
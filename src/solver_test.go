@@ -0,0 +1,197 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSolveShippedMaps(t *testing.T) {
+	names := listMaps()
+	if len(names) == 0 {
+		t.Fatal("no maps found")
+	}
+	for _, name := range names {
+		for _, d := range difficulties {
+			report, err := solveLevel(name, d.name)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+			if !report.ExitReachable {
+				t.Errorf("%s: exit can't be reached on %s", name, d.name)
+			}
+		}
+	}
+}
+
+func TestSolveFixtures(t *testing.T) {
+	tests := []struct {
+		name       string
+		mapFile    *MapFile
+		difficulty string // Normal if not set
+		exit       bool
+		gates      []string // Kind of each gate, in the order they're opened
+		keys       []string
+		items      [][2]int // Unreachable items
+		monsters   [][2]int // Unreachable monsters
+		secrets    [][2]int // Inaccessible secrets
+	}{
+		{
+			name: "open room",
+			mapFile: testMap([]string{
+				"#####",
+				"#P.a#",
+				"###X#",
+			}),
+			exit: true,
+		},
+		{
+			name: "key behind a door",
+			mapFile: testMap([]string{
+				"#########",
+				"#P.D.k#X#",
+				"###R###.#",
+				"#.......#",
+				"#########",
+			}),
+			exit:  true,
+			gates: []string{"door", "key_red door"},
+			keys:  []string{"key_red"},
+		},
+		{
+			name: "not enough keys",
+			mapFile: testMap([]string{
+				"#########",
+				"#P.k#...#",
+				"###R#.o.#",
+				"#..R..#X#",
+				"#########",
+			}),
+			exit:     false,
+			gates:    []string{"key_red door"},
+			keys:     []string{"key_red"},
+			monsters: [][2]int{{6, 2}},
+		},
+		{
+			name: "key door to a dead end first",
+			mapFile: testMap([]string{
+				"#######",
+				"#.R.kP#",
+				"#####R#",
+				"#####.X",
+				"#######",
+			}),
+			exit:  true,
+			gates: []string{"key_red door"},
+			keys:  []string{"key_red"},
+		},
+		{
+			name: "sealed room",
+			mapFile: testMap([]string{
+				"#######",
+				"#P.X#a#",
+				"#######",
+			}),
+			exit:  true,
+			items: [][2]int{{5, 1}},
+		},
+		{
+			name: "secret out of reach",
+			mapFile: testMap([]string{
+				"########",
+				"#P.#.S.#",
+				"#.S#####",
+				"#X##....",
+			}),
+			exit:    true,
+			gates:   []string{"secret"},
+			secrets: [][2]int{{5, 1}},
+		},
+		{
+			name: "teleporter",
+			mapFile: testMap([]string{
+				"########",
+				"#P.##aX#",
+				"########",
+			}, &MapFileCell{X: 2, Y: 1, Type: "t", Value: "5,1"}),
+			exit:  true,
+			gates: []string{"teleport"},
+		},
+		{
+			name: "key only on easy",
+			mapFile: testMap([]string{
+				"#######",
+				"#P.kR.X",
+				"#######",
+			}, &MapFileCell{X: 3, Y: 1, Type: "i", Value: "key_red", Difficulties: []string{"easy"}}),
+			exit: false,
+		},
+		{
+			name: "key only on easy, playing easy",
+			mapFile: testMap([]string{
+				"#######",
+				"#P.kR.X",
+				"#######",
+			}, &MapFileCell{X: 3, Y: 1, Type: "i", Value: "key_red", Difficulties: []string{"easy"}}),
+			difficulty: "easy",
+			exit:       true,
+			gates:      []string{"key_red door"},
+			keys:       []string{"key_red"},
+		},
+		{
+			name: "wall missing on nightmare",
+			mapFile: testMap([]string{
+				"######",
+				"#P.#X#",
+				"######",
+			}, &MapFileCell{X: 3, Y: 1, Type: "w", Value: "brick_gray_1", Difficulties: []string{"easy", "normal", "hard"}}),
+			difficulty: "nightmare",
+			exit:       true,
+		},
+	}
+
+	for _, test := range tests {
+		difficulty := test.difficulty
+		if difficulty == "" {
+			difficulty = difficulties[defaultDifficulty].name
+		}
+		report, err := solveMapFile(test.mapFile, difficulty)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		gates := []string{}
+		for _, gate := range report.Gates {
+			gates = append(gates, gate.Kind)
+		}
+		if report.ExitReachable != test.exit {
+			t.Errorf("%s: exit reachable is %v, expected %v", test.name, report.ExitReachable, test.exit)
+		}
+		if len(gates) > 0 || len(test.gates) > 0 {
+			if !reflect.DeepEqual(gates, test.gates) {
+				t.Errorf("%s: opened %v, expected %v", test.name, gates, test.gates)
+			}
+		}
+		if len(report.Keys) > 0 || len(test.keys) > 0 {
+			if !reflect.DeepEqual(report.Keys, test.keys) {
+				t.Errorf("%s: collected %v, expected %v", test.name, report.Keys, test.keys)
+			}
+		}
+		if len(report.UnreachableItems) > 0 || len(test.items) > 0 {
+			if !reflect.DeepEqual(report.UnreachableItems, test.items) {
+				t.Errorf("%s: unreachable items %v, expected %v", test.name, report.UnreachableItems, test.items)
+			}
+		}
+		if len(report.UnreachableMonsters) > 0 || len(test.monsters) > 0 {
+			if !reflect.DeepEqual(report.UnreachableMonsters, test.monsters) {
+				t.Errorf("%s: unreachable monsters %v, expected %v", test.name, report.UnreachableMonsters, test.monsters)
+			}
+		}
+		if len(report.InaccessibleSecrets) > 0 || len(test.secrets) > 0 {
+			if !reflect.DeepEqual(report.InaccessibleSecrets, test.secrets) {
+				t.Errorf("%s: inaccessible secrets %v, expected %v", test.name, report.InaccessibleSecrets, test.secrets)
+			}
+		}
+	}
+}
@@ -10,9 +10,7 @@ import (
 
 const spriteImgSize = 32
 const spriteImgSizeH = 16
-
-// Used for rendering sprites with occlusion
-var depthBuffer []float64
+const spriteHeightRatio = 0.8 // Sprites are drawn a little shorter than walls
 
 type Sprite struct {
 	x     float64
@@ -27,7 +25,7 @@ type Sprite struct {
 }
 
 func (g *Game) addSprite(kind string, x, y float64, angle float64, speed float64, size float64) *Sprite {
-	if imageCache[kind] == nil {
+	if !imageExists(kind) {
 		log.Printf("ERROR! Sprite image not found: %s", kind)
 		return nil
 	}
@@ -72,53 +70,50 @@ func (s *Sprite) draw(screen *ebiten.Image, g *Game) {
 		return
 	}
 
-	// Sizing and scaling based on depth
-	spriteDist := (1.0 / s.dist)
-	darken := (1 - (s.dist / viewDistance)) + 0.1
-	spriteScale := spriteDist * float64(winHeight)
 	// Direction to player
 	spriteDir := math.Atan2(s.y-g.player.y, s.x-g.player.x)
 
-	// I don't know what this really does, actually no fucking clue
-	for ; spriteDir-g.player.angle > math.Pi; spriteDir -= 2 * math.Pi {
-	}
-	for ; spriteDir-g.player.angle < -math.Pi; spriteDir += 2 * math.Pi {
+	// Angle relative to where the player is facing, wrapped into -Pi ~ Pi
+	relAngle := math.Remainder(spriteDir-g.player.angle, 2*math.Pi)
+	if math.Abs(relAngle) >= math.Pi/2 {
+		return
 	}
 
-	winWidthF := float64(winWidth)
-	// The X coordinate of the sprite
-	hOffset := (spriteDir-g.player.angle)/g.player.fov*(winWidthF) + (winWidthF / 2) - (spriteImgSizeH * spriteScale)
+	// Sizing and scaling based on depth, using the same projection as the walls
+	perpDist := s.dist * math.Cos(relAngle)
+	darken := (1 - (s.dist / viewDistance)) + 0.1
+	wallHeight := cellSize * projDist / perpDist
+	spriteHeight := wallHeight * spriteHeightRatio
+	spriteScale := spriteHeight / spriteImgSize
 
-	// TODO: Remove this? - Crude culling
-	centerX := hOffset + (spriteImgSizeH * spriteScale)
-	if centerX < 0 || centerX > winWidthF {
+	// The X coordinate of the sprite
+	hOffset := float64(winWidth)/2 + math.Tan(relAngle)*projDist - spriteHeight/2
+	if hOffset+spriteHeight < 0 || hOffset > float64(winWidth) {
 		return
 	}
 
-	// The Y coordinate of the sprite
-	// HACK: HERE BE EVIL!
-	vOffset := float64(winHeightHalf) - spriteImgSize*magicWall*spriteDist*magicSprite
+	// The Y coordinate of the sprite, standing on the floor
+	vOffset := float64(winHeightHalf) + wallHeight/2 - spriteHeight
 
 	// To position the sprite
 	spriteOp := &ebiten.DrawImageOptions{}
 	spriteOp.GeoM.Scale(spriteScale, spriteScale)
 	spriteOp.GeoM.Translate(hOffset, vOffset)
-	spriteOp.ColorM.Scale(darken, darken, darken, s.alpha)
+	g.applyFog(&spriteOp.ColorM, darken)
+	spriteOp.ColorM.Scale(1, 1, 1, s.alpha)
 
 	// Slice the sprite image into strips and render each one
 	spriteImg := s.image
 	for slice := 0; slice < spriteImgSize; slice++ {
-		// Each loop move the slice along with scaling taken into account
-		spriteOp.GeoM.Translate(spriteScale, 0)
-
-		// Check the depth buffer, and skip if the sprite is a wall
-		depthBufferX := int(math.Floor(spriteOp.GeoM.Element(0, 2)) / viewRaysRatio)
-		if depthBufferX < 0 || depthBufferX >= viewRays || depthBuffer[int(depthBufferX)] < s.dist {
-			continue
+		// Check the depth buffer, and skip if the sprite is behind a wall
+		depthBufferX := int(math.Floor(spriteOp.GeoM.Element(0, 2)+spriteScale/2) / viewRaysRatio)
+		if depthBufferX >= 0 && depthBufferX < viewRays && g.depthBuffer[depthBufferX] >= s.dist {
+			// Draw the sprite slice
+			sliceImg := spriteImg.SubImage(image.Rect(slice, 0, slice+1, spriteImgSize)).(*ebiten.Image)
+			screen.DrawImage(sliceImg, spriteOp)
 		}
 
-		// Draw the sprite slice
-		sliceImg := spriteImg.SubImage(image.Rect(slice, 0, slice+1, spriteImgSize)).(*ebiten.Image)
-		screen.DrawImage(sliceImg, spriteOp)
+		// Each loop move the slice along with scaling taken into account
+		spriteOp.GeoM.Translate(spriteScale, 0)
 	}
 }
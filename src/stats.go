@@ -9,8 +9,19 @@ type Stats struct {
 	itemsFound   int
 	secretsTotal int
 	secretsFound int
-	startTime    time.Time
-	endTime      time.Time
+	score        int
+	doorsOpened  int // Doors, secret walls & anything opened by switches
+	startTick    int
+	endTick      int // Negative until the level is finished
+}
+
+// How long the level took, or has taken so far, in game time
+func (s *Stats) elapsed(now int) time.Duration {
+	end := s.endTick
+	if end < 0 {
+		end = now
+	}
+	return ticksToDuration(end - s.startTick)
 }
 
 func (s *Stats) init() {
@@ -20,4 +31,19 @@ func (s *Stats) init() {
 	s.itemsFound = 0
 	s.secretsTotal = 0
 	s.secretsFound = 0
+	s.score = 0
+	s.doorsOpened = 0
+	s.endTick = -1
+}
+
+// Add the counts from another set of stats to these, used for campaign totals
+func (s *Stats) add(o Stats) {
+	s.monsters += o.monsters
+	s.kills += o.kills
+	s.itemsTotal += o.itemsTotal
+	s.itemsFound += o.itemsFound
+	s.secretsTotal += o.secretsTotal
+	s.secretsFound += o.secretsFound
+	s.score += o.score
+	s.doorsOpened += o.doorsOpened
 }
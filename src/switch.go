@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Things a switch can do when switched on, switching it off undoes them
+const (
+	switchRemove = "remove" // Remove walls, or open doors and keep them open
+	switchWall   = "wall"   // Put up walls, the texture comes first
+	switchOpen   = "open"   // Open doors and keep them open
+	switchClose  = "close"  // Close doors
+	switchSpawn  = "spawn"  // Spawn monsters, the kind comes first, these aren't undone
+	switchLight  = "light"  // Change the light level of the whole map, 1.0 is normal
+)
+
+type SwitchAction struct {
+	verb  string
+	value string // Wall texture, monster kind or light level, depending on the verb
+	cells [][2]int
+}
+
+// Switches are walls which do something when used, they are either on or off
+type Switch struct {
+	wall    *Wall
+	on      bool
+	actions []SwitchAction
+	toggle  bool     // Can be switched off again by using it
+	timer   int      // Switches itself off after this many ticks, 0 for never
+	links   [][2]int // Other switches flipped along with this one
+	offTick int      // When a timed switch will switch off
+
+	removed   map[[2]int]*Wall // Walls taken away, put back when switched off
+	created   map[[2]int]bool  // Walls put up, taken away when switched off
+	lastLight float64          // Light level before it was changed
+}
+
+// ===========================================================
+// Parse the switch extras, e.g. ["switch", "remove", "12,4", "13,4", "toggle"]
+// Actions are followed by their cells, options are "toggle", "timer" with
+// the seconds until it switches off, and "link" with the cells of other
+// switches. The older ["switch", "12", "4"] form removes a single cell
+// ===========================================================
+func parseSwitch(extra []string) (*Switch, error) {
+	s := &Switch{
+		removed: map[[2]int]*Wall{},
+		created: map[[2]int]bool{},
+	}
+	if len(extra) < 2 || extra[0] != "switch" {
+		return nil, fmt.Errorf("switch has no actions")
+	}
+
+	// The original form, a single target cell
+	if len(extra) == 3 {
+		x, errX := strconv.Atoi(extra[1])
+		y, errY := strconv.Atoi(extra[2])
+		if errX == nil && errY == nil {
+			s.actions = []SwitchAction{{verb: switchRemove, cells: [][2]int{{x, y}}}}
+			return s, nil
+		}
+	}
+
+	// Cells follow on from position i, returns them and the position of the last one
+	cellsFrom := func(i int) ([][2]int, int) {
+		cells := [][2]int{}
+		for ; i < len(extra); i++ {
+			cell, ok := parseCell(extra[i])
+			if !ok {
+				break
+			}
+			cells = append(cells, cell)
+		}
+		return cells, i - 1
+	}
+
+	for i := 1; i < len(extra); i++ {
+		switch verb := extra[i]; verb {
+		case switchRemove, switchOpen, switchClose:
+			action := SwitchAction{verb: verb}
+			if action.cells, i = cellsFrom(i + 1); len(action.cells) == 0 {
+				return nil, fmt.Errorf("%s needs one or more x,y cells", verb)
+			}
+			s.actions = append(s.actions, action)
+
+		case switchWall, switchSpawn:
+			if i+1 >= len(extra) {
+				return nil, fmt.Errorf("%s needs a kind and one or more x,y cells", verb)
+			}
+			action := SwitchAction{verb: verb, value: extra[i+1]}
+			if action.cells, i = cellsFrom(i + 2); len(action.cells) == 0 {
+				return nil, fmt.Errorf("%s needs one or more x,y cells", verb)
+			}
+			s.actions = append(s.actions, action)
+
+		case switchLight:
+			if i+1 >= len(extra) {
+				return nil, fmt.Errorf("light needs a level")
+			}
+			if level, err := strconv.ParseFloat(extra[i+1], 64); err != nil || level <= 0 {
+				return nil, fmt.Errorf("light level '%s' is not a positive number", extra[i+1])
+			}
+			s.actions = append(s.actions, SwitchAction{verb: verb, value: extra[i+1]})
+			i++
+
+		case "toggle":
+			s.toggle = true
+
+		case "timer":
+			if i+1 >= len(extra) {
+				return nil, fmt.Errorf("timer needs a number of seconds")
+			}
+			seconds, err := strconv.ParseFloat(extra[i+1], 64)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("timer '%s' is not a positive number of seconds", extra[i+1])
+			}
+			s.timer = int(seconds * ticksPerSecond)
+			i++
+
+		case "link":
+			if s.links, i = cellsFrom(i + 1); len(s.links) == 0 {
+				return nil, fmt.Errorf("link needs one or more x,y cells")
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown switch action '%s'", verb)
+		}
+	}
+
+	if len(s.actions) == 0 && len(s.links) == 0 {
+		return nil, fmt.Errorf("switch has no actions")
+	}
+	return s, nil
+}
+
+// Cells are written as "x,y"
+func parseCell(s string) ([2]int, bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return [2]int{}, false
+	}
+	x, errX := strconv.Atoi(parts[0])
+	y, errY := strconv.Atoi(parts[1])
+	return [2]int{x, y}, errX == nil && errY == nil
+}
+
+// Every cell the switch refers to, used to check they're all on the map
+func (s *Switch) cells() [][2]int {
+	cells := append([][2]int{}, s.links...)
+	for _, action := range s.actions {
+		cells = append(cells, action.cells...)
+	}
+	return cells
+}
+
+// Cells the switch opens up for the player to walk through
+func (s *Switch) opens() [][2]int {
+	cells := [][2]int{}
+	for _, action := range s.actions {
+		if action.verb == switchRemove || action.verb == switchOpen {
+			cells = append(cells, action.cells...)
+		}
+	}
+	return cells
+}
+
+func newSwitchWall(x, y int, kind string, s *Switch) *Wall {
+	wall := &Wall{
+		x:          x,
+		y:          y,
+		image:      imageCache["walls/"+kind],
+		decoration: imageCache["decoration/switch"],
+		sw:         s,
+		actionFunc: func(g *Game) {
+			s.use(g)
+		},
+	}
+	s.wall = wall
+	return wall
+}
+
+// The switch in a cell, if there is one
+func (g *Game) switchAt(cell [2]int) *Switch {
+	if !g.inBounds(cell[0], cell[1]) || g.mapdata[cell[0]][cell[1]] == nil {
+		return nil
+	}
+	return g.mapdata[cell[0]][cell[1]].sw
+}
+
+// ===========================================================
+// The player uses the switch, flipping it and any linked switches
+// ===========================================================
+func (s *Switch) use(g *Game) {
+	if s.on && (!s.toggle || s.blocked(g)) {
+		playSound("grunt", 1.0, false)
+		return
+	}
+
+	s.set(g, !s.on)
+	for _, cell := range s.links {
+		if other := g.switchAt(cell); other != nil && other != s {
+			other.set(g, !other.on)
+		}
+	}
+	g.makeNoise(float64(s.wall.x)*cellSize+cellSize/2, float64(s.wall.y)*cellSize+cellSize/2, noiseSwitch)
+}
+
+// Switch on or off, doing or undoing the actions
+func (s *Switch) set(g *Game, on bool) {
+	// Walls can't come back down on top of anything, so it stays on until they're clear
+	if !on && s.blocked(g) {
+		return
+	}
+	s.on = on
+	playSound("switch", 1.0, false)
+
+	if on {
+		s.wall.decoration = imageCache["decoration/switch-1"]
+		s.offTick = 0
+		if s.timer > 0 {
+			s.offTick = g.ticks + s.timer
+		}
+		for _, action := range s.actions {
+			s.do(g, action)
+		}
+		return
+	}
+
+	s.wall.decoration = imageCache["decoration/switch"]
+	s.offTick = 0
+	for i := len(s.actions) - 1; i >= 0; i-- {
+		s.undo(g, s.actions[i])
+	}
+}
+
+// Something is in the way of putting back a removed wall
+func (s *Switch) blocked(g *Game) bool {
+	for cell := range s.removed {
+		if g.mapdata[cell[0]][cell[1]] == nil && g.cellOccupied(cell[0], cell[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Switch) do(g *Game, action SwitchAction) {
+	for _, cell := range action.cells {
+		if !g.inBounds(cell[0], cell[1]) {
+			continue
+		}
+		wall := g.mapdata[cell[0]][cell[1]]
+		cx, cy := float64(cell[0])*cellSize+cellSize/2, float64(cell[1])*cellSize+cellSize/2
+
+		switch action.verb {
+		case switchRemove, switchOpen:
+			if wall != nil && wall.door != nil {
+				wall.door.holdOpen(g)
+			} else if wall != nil && action.verb == switchRemove {
+				s.removed[cell] = wall
+				g.mapdata[cell[0]][cell[1]] = nil
+				g.stats.doorsOpened++
+				g.makeNoise(cx, cy, noiseDoor)
+			}
+
+		case switchClose:
+			if wall != nil && wall.door != nil {
+				wall.door.startClosing()
+			}
+
+		case switchWall:
+			if wall == nil && !g.cellOccupied(cell[0], cell[1]) && imageExists("walls/"+action.value) {
+				g.mapdata[cell[0]][cell[1]] = newWall(cell[0], cell[1], action.value)
+				s.created[cell] = true
+			}
+
+		case switchSpawn:
+			if wall == nil && !g.cellOccupied(cell[0], cell[1]) {
+				if mon := g.addMonster(action.value, cell[0], cell[1]); mon != nil {
+					mon.alert(g.player.x, g.player.y)
+				}
+			}
+		}
+	}
+
+	if action.verb == switchLight {
+		s.lastLight = g.light
+		g.light, _ = strconv.ParseFloat(action.value, 64)
+	}
+}
+
+func (s *Switch) undo(g *Game, action SwitchAction) {
+	for _, cell := range action.cells {
+		if !g.inBounds(cell[0], cell[1]) {
+			continue
+		}
+		wall := g.mapdata[cell[0]][cell[1]]
+
+		switch action.verb {
+		case switchRemove, switchOpen:
+			if wall != nil && wall.door != nil {
+				wall.door.release()
+			} else if removed := s.removed[cell]; removed != nil && wall == nil && !g.cellOccupied(cell[0], cell[1]) {
+				g.mapdata[cell[0]][cell[1]] = removed
+				delete(s.removed, cell)
+			}
+
+		case switchClose:
+			if wall != nil && wall.door != nil {
+				wall.door.startOpening(g)
+			}
+
+		case switchWall:
+			if s.created[cell] && !g.cellOccupied(cell[0], cell[1]) {
+				g.mapdata[cell[0]][cell[1]] = nil
+				delete(s.created, cell)
+			}
+		}
+	}
+
+	if action.verb == switchLight {
+		g.light = s.lastLight
+	}
+}
+
+// Put back a wall the switch had put up, when loading a saved game
+func (s *Switch) restoreWall(g *Game, cell [2]int) {
+	for _, action := range s.actions {
+		if action.verb != switchWall || !g.inBounds(cell[0], cell[1]) || !imageExists("walls/"+action.value) {
+			continue
+		}
+		for _, c := range action.cells {
+			if c == cell {
+				g.mapdata[cell[0]][cell[1]] = newWall(cell[0], cell[1], action.value)
+				s.created[cell] = true
+				return
+			}
+		}
+	}
+}
+
+// Keep hold of a wall the switch removed, when loading a saved game
+func (s *Switch) holdRemoved(g *Game, cell [2]int) {
+	wall := g.mapdata[cell[0]][cell[1]]
+	if !s.on || wall == nil || wall.door != nil {
+		return
+	}
+	for _, action := range s.actions {
+		if action.verb != switchRemove {
+			continue
+		}
+		for _, c := range action.cells {
+			if c == cell {
+				s.removed[cell] = wall
+				return
+			}
+		}
+	}
+}
+
+// Timed switches switch themselves off, called every tick
+func (g *Game) updateSwitches() {
+	for _, s := range g.switches {
+		if s.on && s.offTick > 0 && g.ticks >= s.offTick {
+			s.set(g, false)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSwitchWaitsForClearCell(t *testing.T) {
+	g := testGame(t, testMap([]string{
+		"#######",
+		"#P..#.#",
+		"#######",
+	}, &MapFileCell{X: 1, Y: 0, Type: "w", Value: "brick_gray_1", Extra: []string{"switch", "remove", "4,1", "toggle", "timer", "1"}}))
+	sw := g.switchAt([2]int{1, 0})
+
+	sw.use(g)
+	if !sw.on || g.mapdata[4][1] != nil {
+		t.Fatal("switch should have removed the wall")
+	}
+
+	// Standing where the wall was, it can't be switched off
+	g.player.moveToCell(4, 1)
+	sw.use(g)
+	g.run(ticksPerSecond*2, 0)
+	if !sw.on || g.mapdata[4][1] != nil {
+		t.Fatal("switch went off with the player in the way of the wall")
+	}
+
+	// The timer has run out, so it goes off as soon as the way is clear
+	g.player.moveToCell(2, 1)
+	g.step(0)
+	if sw.on || g.mapdata[4][1] == nil {
+		t.Error("wall should be back once the player moved out of the way")
+	}
+}
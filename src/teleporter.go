@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Teleporter pads sit on the floor and move whatever walks onto them to
+// another cell. Teleporter walls do the same for the player when used
+type Teleporter struct {
+	x, y        int
+	dest        [2]int
+	facing      int  // 0 ~ 3 like the player start, -1 keeps the current facing
+	monsters    bool // Monsters are teleported too, not just the player
+	projectiles bool // Projectiles are teleported too
+}
+
+// ===========================================================
+// Parse where a teleporter goes, e.g. ["12,4", "2", "monsters"]. The
+// facing and the flags are optional, only pads can take the flags
+// ===========================================================
+func parseTeleporter(args []string, pad bool) (*Teleporter, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("teleporter needs a destination x,y")
+	}
+	dest, ok := parseCell(args[0])
+	if !ok {
+		return nil, fmt.Errorf("teleporter destination '%s' should be x,y", args[0])
+	}
+
+	t := &Teleporter{dest: dest, facing: -1}
+	for _, arg := range args[1:] {
+		switch {
+		case arg == "monsters" && pad:
+			t.monsters = true
+		case arg == "projectiles" && pad:
+			t.projectiles = true
+		default:
+			facing, err := strconv.Atoi(arg)
+			if err != nil || facing < 0 || facing > 3 {
+				return nil, fmt.Errorf("unknown teleporter option '%s'", arg)
+			}
+			t.facing = facing
+		}
+	}
+	return t, nil
+}
+
+func newTeleporterWall(x, y int, kind string, t *Teleporter) *Wall {
+	t.x, t.y = x, y
+	return &Wall{
+		x:          x,
+		y:          y,
+		image:      imageCache["walls/"+kind],
+		decoration: imageCache["decoration/teleport"],
+		actionFunc: func(g *Game) {
+			g.teleportPlayer(t.dest, t.facing)
+		},
+	}
+}
+
+// Pads are drawn as a sprite standing in the cell
+func (g *Game) addTeleporterPad(x, y int, t *Teleporter) {
+	t.x, t.y = x, y
+	g.teleporters[[2]int{x, y}] = t
+	g.addSprite("effects/teleporter", float64(x)*cellSize+cellSize/2, float64(y)*cellSize+cellSize/2, 0, 0, 0)
+}
+
+// The pad something has just moved onto, nil if it's still in the same cell or there isn't one
+func (g *Game) padAt(oldX, oldY, newX, newY float64) *Teleporter {
+	oldCell := [2]int{int(math.Floor(oldX / cellSize)), int(math.Floor(oldY / cellSize))}
+	newCell := [2]int{int(math.Floor(newX / cellSize)), int(math.Floor(newY / cellSize))}
+	if oldCell == newCell {
+		return nil
+	}
+	return g.teleporters[newCell]
+}
+
+// ===========================================================
+// Move a monster or projectile that's walked or flown onto a pad, as long
+// as the pad takes them and there's room at the other end. Returns true if
+// it was moved
+// ===========================================================
+func (g *Game) teleportSprite(s *Sprite, oldX, oldY float64, monster bool) bool {
+	t := g.padAt(oldX, oldY, s.x, s.y)
+	if t == nil || (monster && !t.monsters) || (!monster && !t.projectiles) {
+		return false
+	}
+	if !g.isWalkable(t.dest[0], t.dest[1]) || (monster && g.cellOccupied(t.dest[0], t.dest[1])) {
+		return false
+	}
+
+	s.x = float64(t.dest[0])*cellSize + cellSize/2
+	s.y = float64(t.dest[1])*cellSize + cellSize/2
+	if t.facing >= 0 {
+		s.angle = facingAngle(t.facing)
+	}
+	if monster {
+		playSound("whoosh", 0.5, false)
+		g.makeNoise(s.x, s.y, noiseDoor)
+	}
+	return true
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Events which set off a trigger
+const (
+	triggerStep    = "step"    // The player steps onto any cell in the area
+	triggerEnter   = "enter"   // The player goes into the area from outside it
+	triggerCleared = "cleared" // Every monster in the area has been killed
+	triggerPickup  = "pickup"  // An item is picked up, optionally of one kind or in the area
+)
+
+// Triggers as held in the map file, e.g.
+// {"on": "enter", "area": [4, 4, 8, 6], "actions": [["spawn", "skeleton", "5,5"], ["message", "Ambush!"]]}
+type MapFileTrigger struct {
+	ID      string     `json:"id,omitempty"`
+	On      string     `json:"on"`
+	Area    []int      `json:"area,omitempty"` // Cells x1,y1,x2,y2 or a single cell x,y
+	Item    string     `json:"item,omitempty"` // Item kind for pickup triggers
+	Repeat  bool       `json:"repeat,omitempty"`
+	Delay   float64    `json:"delay,omitempty"` // Seconds between firing and the actions happening
+	Actions [][]string `json:"actions"`
+}
+
+type Trigger struct {
+	id      string
+	on      string
+	area    [4]int // x1, y1, x2, y2 inclusive
+	hasArea bool
+	item    string
+	repeat  bool
+	delay   int // Ticks
+	actions [][]string
+
+	fired  bool     // Has fired at least once
+	due    int      // Tick the delayed actions happen on, 0 when nothing is waiting
+	inside bool     // Player was in the area last tick, for enter triggers
+	guards []uint64 // Monsters in the area when a cleared trigger was armed
+}
+
+// ===========================================================
+// Check a trigger from the map file and turn it into one the game can use
+// ===========================================================
+func parseTrigger(mt MapFileTrigger) (*Trigger, error) {
+	t := &Trigger{
+		id:      mt.ID,
+		on:      mt.On,
+		item:    mt.Item,
+		repeat:  mt.Repeat,
+		delay:   int(mt.Delay * ticksPerSecond),
+		actions: mt.Actions,
+	}
+
+	switch len(mt.Area) {
+	case 0:
+	case 2:
+		t.area = [4]int{mt.Area[0], mt.Area[1], mt.Area[0], mt.Area[1]}
+		t.hasArea = true
+	case 4:
+		t.area = [4]int{mt.Area[0], mt.Area[1], mt.Area[2], mt.Area[3]}
+		if t.area[0] > t.area[2] {
+			t.area[0], t.area[2] = t.area[2], t.area[0]
+		}
+		if t.area[1] > t.area[3] {
+			t.area[1], t.area[3] = t.area[3], t.area[1]
+		}
+		t.hasArea = true
+	default:
+		return nil, fmt.Errorf("area should be x,y or x1,y1,x2,y2")
+	}
+
+	switch t.on {
+	case triggerStep, triggerEnter, triggerCleared:
+		if !t.hasArea {
+			return nil, fmt.Errorf("%s trigger needs an area", t.on)
+		}
+	case triggerPickup:
+	default:
+		return nil, fmt.Errorf("unknown trigger event '%s'", t.on)
+	}
+	if mt.Delay < 0 {
+		return nil, fmt.Errorf("delay can't be negative")
+	}
+
+	if len(t.actions) == 0 {
+		return nil, fmt.Errorf("trigger has no actions")
+	}
+	for _, action := range t.actions {
+		if err := checkTriggerAction(action); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Actions are lists of strings, the first is what to do: message, sound,
+// open, close, spawn, endlevel, teleport or script, see the readme for the details
+func checkTriggerAction(action []string) error {
+	if len(action) == 0 {
+		return fmt.Errorf("empty trigger action")
+	}
+
+	cellsOK := func(cells []string) bool {
+		for _, c := range cells {
+			if _, ok := parseCell(c); !ok {
+				return false
+			}
+		}
+		return len(cells) > 0
+	}
+
+	switch action[0] {
+	case "message", "sound":
+		if len(action) != 2 {
+			return fmt.Errorf("%s needs one value", action[0])
+		}
+	case "open", "close":
+		if !cellsOK(action[1:]) {
+			return fmt.Errorf("%s needs one or more x,y cells", action[0])
+		}
+	case "spawn":
+		if len(action) < 3 || !cellsOK(action[2:]) {
+			return fmt.Errorf("spawn needs a monster and one or more x,y cells")
+		}
+	case "endlevel":
+	case "script":
+		if len(action) != 2 {
+			return fmt.Errorf("script needs the name of a function")
+		}
+	case "teleport":
+		if len(action) < 2 || len(action) > 3 || !cellsOK(action[1:2]) {
+			return fmt.Errorf("teleport needs an x,y cell and optionally the facing")
+		}
+		if len(action) == 3 {
+			if facing, err := strconv.Atoi(action[2]); err != nil || facing < 0 || facing > 3 {
+				return fmt.Errorf("teleport facing '%s' should be 0 ~ 3", action[2])
+			}
+		}
+	default:
+		return fmt.Errorf("unknown trigger action '%s'", action[0])
+	}
+	return nil
+}
+
+// Cells the trigger's actions refer to, used to check they're on the map
+func (t *Trigger) cells() [][2]int {
+	cells := [][2]int{}
+	if t.hasArea {
+		cells = append(cells, [2]int{t.area[0], t.area[1]}, [2]int{t.area[2], t.area[3]})
+	}
+	for _, action := range t.actions {
+		for _, value := range action[1:] {
+			if cell, ok := parseCell(value); ok {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
+
+// Script functions the trigger calls
+func (t *Trigger) scriptCalls() []string {
+	calls := []string{}
+	for _, action := range t.actions {
+		if action[0] == "script" {
+			calls = append(calls, action[1])
+		}
+	}
+	return calls
+}
+
+func (t *Trigger) contains(x, y int) bool {
+	return t.hasArea && x >= t.area[0] && x <= t.area[2] && y >= t.area[1] && y <= t.area[3]
+}
+
+// Fire the trigger, the actions happen now or after the delay
+func (t *Trigger) fire(g *Game) {
+	if (t.fired && !t.repeat) || t.due > 0 {
+		return
+	}
+	t.fired = true
+	if t.delay > 0 {
+		t.due = g.ticks + t.delay
+		return
+	}
+	t.run(g)
+}
+
+func (t *Trigger) run(g *Game) {
+	for _, action := range t.actions {
+		if g.state != GameStateMain {
+			return
+		}
+		g.doTriggerAction(action)
+	}
+}
+
+// ===========================================================
+// Carry out one trigger action, these have been checked when the map loaded
+// ===========================================================
+func (g *Game) doTriggerAction(action []string) {
+	switch action[0] {
+	case "message":
+		g.showMessage(action[1])
+
+	case "sound":
+		playSound(action[1], 1.0, false)
+
+	case "open", "close":
+		for _, value := range action[1:] {
+			cell, _ := parseCell(value)
+			if !g.inBounds(cell[0], cell[1]) || g.mapdata[cell[0]][cell[1]] == nil || g.mapdata[cell[0]][cell[1]].door == nil {
+				log.Printf("WARNING! Trigger can't %s %d,%d, it's not a door", action[0], cell[0], cell[1])
+				continue
+			}
+			if action[0] == "open" {
+				g.mapdata[cell[0]][cell[1]].door.holdOpen(g)
+			} else {
+				g.mapdata[cell[0]][cell[1]].door.startClosing()
+			}
+		}
+
+	case "spawn":
+		for _, value := range action[2:] {
+			cell, _ := parseCell(value)
+			if !g.inBounds(cell[0], cell[1]) || g.mapdata[cell[0]][cell[1]] != nil || g.cellOccupied(cell[0], cell[1]) {
+				continue
+			}
+			if mon := g.addMonster(action[1], cell[0], cell[1]); mon != nil {
+				mon.alert(g.player.x, g.player.y)
+			}
+		}
+
+	case "endlevel":
+		g.endLevel()
+
+	case "script":
+		g.runScript(action[1])
+
+	case "teleport":
+		cell, _ := parseCell(action[1])
+		facing := -1
+		if len(action) == 3 {
+			facing, _ = strconv.Atoi(action[2])
+		}
+		g.teleportPlayer(cell, facing)
+	}
+}
+
+// Move the player somewhere else on the map, facing is 0 ~ 3 or -1 to keep the current facing
+func (g *Game) teleportPlayer(cell [2]int, facing int) {
+	if !g.isWalkable(cell[0], cell[1]) {
+		log.Printf("WARNING! Can't teleport the player into %d,%d, it's blocked", cell[0], cell[1])
+		return
+	}
+	g.player.moveToCell(cell[0], cell[1])
+	if facing >= 0 {
+		g.player.setFacing(facing)
+	}
+	playSound("whoosh", 1.0, false)
+	g.screenFlashWhite(10)
+	g.makeNoise(g.player.x, g.player.y, noiseDoor)
+}
+
+// ===========================================================
+// Check the triggers, called every tick after everything has moved
+// ===========================================================
+func (g *Game) updateTriggers() {
+	cell := [2]int{g.player.cellX, g.player.cellY}
+	moved := cell != g.triggerCell
+	g.triggerCell = cell
+
+	for _, t := range g.triggers {
+		if g.state != GameStateMain {
+			return
+		}
+
+		if t.due > 0 && g.ticks >= t.due {
+			t.due = 0
+			t.run(g)
+			continue
+		}
+
+		switch t.on {
+		case triggerStep:
+			if moved && t.contains(cell[0], cell[1]) {
+				t.fire(g)
+			}
+
+		case triggerEnter:
+			inside := t.contains(cell[0], cell[1])
+			if inside && !t.inside {
+				t.fire(g)
+			}
+			t.inside = inside
+
+		case triggerCleared:
+			if len(t.guards) == 0 {
+				if !t.fired || t.repeat {
+					t.arm(g)
+				}
+				continue
+			}
+
+			// Only the monsters there when it was armed count, wherever they've gone since
+			alive := t.guards[:0]
+			for _, id := range t.guards {
+				if g.monsters[id] != nil {
+					alive = append(alive, id)
+				}
+			}
+			t.guards = alive
+			if len(t.guards) == 0 {
+				t.fire(g)
+			}
+		}
+	}
+}
+
+// Arm a cleared trigger with the monsters in its area, if there are any
+func (t *Trigger) arm(g *Game) {
+	for _, mon := range g.monsterList() {
+		if t.contains(int(mon.sprite.x/cellSize), int(mon.sprite.y/cellSize)) {
+			t.guards = append(t.guards, mon.id)
+		}
+	}
+}
+
+// Called when the player picks up an item
+func (g *Game) triggerPickup(item *Item) {
+	for _, t := range g.triggers {
+		if t.on != triggerPickup || (t.item != "" && t.item != item.def.name) {
+			continue
+		}
+		if t.hasArea && !t.contains(item.cellX, item.cellY) {
+			continue
+		}
+		t.fire(g)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestClearedTrigger(t *testing.T) {
+	mapFile := testMap([]string{
+		"##########",
+		"#P.......#",
+		"#......o.#",
+		"##########",
+	})
+	mapFile.Triggers = []MapFileTrigger{{On: "cleared", Area: []int{6, 1, 8, 2}, Actions: [][]string{{"message", "Cleared"}}}}
+	g := testGame(t, mapFile)
+	trigger := g.triggers[0]
+	orc := g.monsterList()[0]
+
+	g.step(0)
+	if len(trigger.guards) != 1 {
+		t.Fatalf("trigger should be armed with the orc, guarded by %v", trigger.guards)
+	}
+
+	// Leaving the area isn't the same as being killed
+	orc.sprite.x = 2*cellSize + cellSize/2
+	g.step(0)
+	if trigger.fired {
+		t.Fatal("trigger fired when the orc walked out of the area")
+	}
+
+	// Monsters turning up later don't hold it back
+	g.addMonster("orc", 7, 1)
+	orc.kill(g)
+	g.step(0)
+	if !trigger.fired || g.message != "Cleared" {
+		t.Errorf("trigger should fire once the orc is dead, fired %v", trigger.fired)
+	}
+}
+
+func TestClearedTriggerSaved(t *testing.T) {
+	mapFile := testMap([]string{
+		"##########",
+		"#P...o...#",
+		"#......o.#",
+		"##########",
+	})
+	mapFile.Triggers = []MapFileTrigger{{On: "cleared", Area: []int{6, 1, 8, 2}, Actions: [][]string{{"message", "Cleared"}}}}
+	g := testGame(t, mapFile)
+	g.step(0)
+
+	save, err := g.newSaveFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.restore(save); err != nil {
+		t.Fatal(err)
+	}
+	guards := g.triggers[0].guards
+	if len(guards) != 1 || g.monsters[guards[0]] == nil || g.monsters[guards[0]].sprite.x < 6*cellSize {
+		t.Fatalf("trigger should still be guarded by the orc in the area after loading, got %v", guards)
+	}
+
+	g.monsters[guards[0]].kill(g)
+	g.step(0)
+	if !g.triggers[0].fired {
+		t.Error("trigger should fire once the orc is dead")
+	}
+}
@@ -2,8 +2,9 @@ package main
 
 import "log"
 
-func logMessage(v ...interface{}) {
-	if game.ticks%10 == 0 {
+// Only log every few ticks, to stop things logged every tick flooding the output
+func (g *Game) logMessage(v ...interface{}) {
+	if g.ticks%10 == 0 {
 		log.Println(v...)
 	}
 }
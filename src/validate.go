@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ===========================================================
+// The validate subcommand, checks one or all maps and returns
+// an exit code which is non-zero if any problems were found
+// ===========================================================
+func runValidate(args []string) int {
+	names := args
+	if len(names) == 0 {
+		names = listMaps()
+	}
+
+	exitCode := 0
+	if err := loadDefinitions(); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defsProblems := map[string][]string{
+		monsterDefsFile: validateMonsterDefs(),
+		weaponDefsFile:  validateWeaponDefs(),
+		itemDefsFile:    validateItemDefs(),
+	}
+	for _, file := range []string{monsterDefsFile, weaponDefsFile, itemDefsFile} {
+		if problems := defsProblems[file]; len(problems) > 0 {
+			exitCode = 1
+			fmt.Printf("%s: %d problem(s)\n", file, len(problems))
+			for _, problem := range problems {
+				fmt.Printf("  - %s\n", problem)
+			}
+		}
+	}
+
+	for _, name := range names {
+		// Allow paths like maps/Caverns.json as well as plain names
+		name = strings.TrimSuffix(filepath.Base(name), ".json")
+
+		problems := validateMap(name)
+		if len(problems) == 0 {
+			fmt.Printf("%s: OK\n", name)
+			continue
+		}
+
+		exitCode = 1
+		fmt.Printf("%s: %d problem(s)\n", name, len(problems))
+		for _, problem := range problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+	}
+
+	return exitCode
+}
+
+// ===========================================================
+// Check a map for problems that would break it at runtime
+// ===========================================================
+func validateMap(name string) []string {
+	mapFile, err := readMapFile(name)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	report := func(x, y int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%d,%d: ", x, y)+fmt.Sprintf(format, args...))
+	}
+
+	// Put the cells into a grid so we can check neighbours & targets
+	grid := mapFile.grid()
+	inBounds := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < mapFile.Width && y < mapFile.Height
+	}
+	for _, cellRow := range mapFile.Cells {
+		for _, cell := range cellRow {
+			if cell != nil && !inBounds(cell.X, cell.Y) {
+				report(cell.X, cell.Y, "cell is outside the %dx%d map", mapFile.Width, mapFile.Height)
+			}
+		}
+	}
+
+	// Teleporters have to land somewhere the player can stand
+	checkTeleporter := func(x, y int, args []string, pad bool) {
+		t, err := parseTeleporter(args, pad)
+		if err != nil {
+			report(x, y, "bad teleporter, %v", err)
+			return
+		}
+		dx, dy := t.dest[0], t.dest[1]
+		switch {
+		case !inBounds(dx, dy):
+			report(x, y, "teleporter destination %d,%d is outside the map", dx, dy)
+		case dx == x && dy == y:
+			report(x, y, "teleporter goes to itself")
+		case grid[dx][dy] != nil && (grid[dx][dy].Type == "w" || grid[dx][dy].Type == "d"):
+			report(x, y, "teleporter destination %d,%d is a wall or door", dx, dy)
+		}
+	}
+
+	players := []*MapFileCell{}
+	exits := 0
+	openable := map[[2]int]bool{} // Walls that can be removed during play, by secrets or switches
+
+	for x := 0; x < mapFile.Width; x++ {
+		for y := 0; y < mapFile.Height; y++ {
+			cell := grid[x][y]
+			if cell == nil {
+				continue
+			}
+
+			for _, d := range cell.Difficulties {
+				if _, err := parseDifficulty(d); err != nil {
+					report(x, y, "%v", err)
+				}
+			}
+			if cell.Type == "p" && len(cell.Difficulties) > 0 {
+				report(x, y, "player start can't be limited to some difficulties")
+			}
+
+			if cell.Floor != "" && !imageExists(cell.Floor) {
+				report(x, y, "unknown floor texture '%s'", cell.Floor)
+			}
+			if cell.Ceiling != "" && cell.Ceiling != skyCeiling && !imageExists(cell.Ceiling) {
+				report(x, y, "unknown ceiling texture '%s'", cell.Ceiling)
+			}
+
+			switch cell.Type {
+			case "w":
+				if !imageExists("walls/" + cell.Value) {
+					report(x, y, "unknown wall texture '%s'", cell.Value)
+				}
+				if len(cell.Extra) == 0 {
+					break
+				}
+
+				switch cell.Extra[0] {
+				case "deco":
+					if len(cell.Extra) < 2 || !imageExists("decoration/"+cell.Extra[1]) {
+						report(x, y, "unknown decoration %v", cell.Extra[1:])
+					}
+				case "secret":
+					openable[[2]int{x, y}] = true
+				case "exit":
+					exits++
+				case "switch":
+					sw, err := parseSwitch(cell.Extra)
+					if err != nil {
+						report(x, y, "bad switch, %v", err)
+						break
+					}
+					for _, action := range sw.actions {
+						switch action.verb {
+						case switchWall:
+							if !imageExists("walls/" + action.value) {
+								report(x, y, "switch wall '%s' has no texture", action.value)
+							}
+						case switchSpawn:
+							if monsterDefs[action.value] == nil {
+								report(x, y, "switch spawns unknown monster '%s'", action.value)
+							}
+						}
+						for _, t := range action.cells {
+							if !inBounds(t[0], t[1]) {
+								report(x, y, "switch target %d,%d is outside the map", t[0], t[1])
+								continue
+							}
+							target := grid[t[0]][t[1]]
+							switch action.verb {
+							case switchRemove:
+								if target == nil || (target.Type != "w" && target.Type != "d") {
+									report(x, y, "switch target %d,%d is not a wall or door", t[0], t[1])
+								}
+							case switchOpen, switchClose:
+								if target == nil || target.Type != "d" {
+									report(x, y, "switch target %d,%d is not a door", t[0], t[1])
+								}
+							case switchWall, switchSpawn:
+								if target != nil && (target.Type == "w" || target.Type == "d") {
+									report(x, y, "switch target %d,%d is already a wall or door", t[0], t[1])
+								}
+							}
+						}
+						if action.verb == switchRemove || action.verb == switchOpen {
+							for _, t := range action.cells {
+								openable[t] = true
+							}
+						}
+					}
+					for _, t := range sw.links {
+						if !inBounds(t[0], t[1]) {
+							report(x, y, "switch link %d,%d is outside the map", t[0], t[1])
+							continue
+						}
+						if target := grid[t[0]][t[1]]; target == nil || target.Type != "w" || len(target.Extra) == 0 || target.Extra[0] != "switch" {
+							report(x, y, "switch link %d,%d is not a switch", t[0], t[1])
+						}
+					}
+				case "teleport":
+					checkTeleporter(x, y, cell.Extra[1:], false)
+				default:
+					report(x, y, "unknown wall extra '%s'", cell.Extra[0])
+				}
+
+			case "d":
+				if !imageExists("doors/" + cell.Value) {
+					report(x, y, "unknown door '%s'", cell.Value)
+				}
+				oneWay, _, err := parseDoorFlags(cell.Extra)
+				if err != nil {
+					report(x, y, "%v", err)
+				} else if oneWay != "" {
+					side := sideCell(x, y, oneWay)
+					if !inBounds(side[0], side[1]) || (grid[side[0]][side[1]] != nil && grid[side[0]][side[1]].Type == "w") {
+						report(x, y, "oneway door opens from the '%s' side, but there's a wall there", oneWay)
+					}
+				}
+
+			case "i":
+				if itemDefs[cell.Value] == nil {
+					report(x, y, "unknown item '%s', should be one of %v", cell.Value, itemNames())
+				}
+
+			case "m":
+				if monsterDefs[cell.Value] == nil {
+					report(x, y, "unknown monster '%s', should be one of %v", cell.Value, monsterNames())
+				}
+				if len(cell.Extra) == 0 {
+					break
+				}
+				patrol, _, err := parseMonsterOrders(cell.Extra)
+				if err != nil {
+					report(x, y, "bad monster orders, %v", err)
+				}
+				for _, p := range patrol {
+					if !inBounds(p[0], p[1]) {
+						report(x, y, "patrol cell %d,%d is outside the map", p[0], p[1])
+					} else if target := grid[p[0]][p[1]]; target != nil && (target.Type == "w" || target.Type == "d") {
+						report(x, y, "patrol cell %d,%d is not open", p[0], p[1])
+					}
+				}
+
+			case "t":
+				checkTeleporter(x, y, append([]string{cell.Value}, cell.Extra...), true)
+
+			case "p":
+				players = append(players, cell)
+				if facing, err := strconv.Atoi(cell.Value); err != nil || facing < 0 || facing > 3 {
+					report(x, y, "player facing '%s' should be 0 to 3", cell.Value)
+				}
+
+			case "":
+				// Empty cell
+
+			default:
+				report(x, y, "unknown cell type '%s'", cell.Type)
+			}
+		}
+	}
+
+	if len(players) == 0 {
+		problems = append(problems, "no player start")
+	}
+	if len(players) > 1 {
+		for _, p := range players[1:] {
+			report(p.X, p.Y, "more than one player start")
+		}
+	}
+	if exits == 0 {
+		problems = append(problems, "no exit")
+	}
+	if mapFile.NextLevel != "" {
+		if _, err := os.Stat("./maps/" + mapFile.NextLevel + ".json"); err != nil {
+			problems = append(problems, fmt.Sprintf("next level '%s' does not exist", mapFile.NextLevel))
+		}
+	}
+
+	var script *Script
+	if mapFile.Script != "" {
+		if script, err = loadScript(mapFile.Script); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if script != nil {
+		// Only names written straight into the script can be checked
+		type scriptProblem struct {
+			line int
+			text string
+		}
+		scriptProblems := []scriptProblem{}
+		script.walk(func(n *scriptNode) {
+			if n.kind != "call" || len(n.args) == 0 {
+				return
+			}
+			arg := map[string]int{"spawn": 0, "give": 0, "holding": 0, "sound": 0, "setWall": 2}
+			i, ok := arg[n.name]
+			if !ok {
+				return
+			}
+			name, ok := n.args[i].value.(string)
+			if !ok || n.args[i].kind != "value" {
+				return
+			}
+			problem := ""
+			switch {
+			case n.name == "spawn" && monsterDefs[name] == nil:
+				problem = "spawns unknown monster '%s'"
+			case (n.name == "give" || n.name == "holding") && itemDefs[name] == nil && weaponDefs[name] == nil:
+				problem = "unknown item '%s'"
+			case n.name == "sound":
+				if _, err := os.Stat("./sounds/" + name + ".wav"); err != nil {
+					problem = "unknown sound '%s'"
+				}
+			case n.name == "setWall" && name != "" && !imageExists("walls/"+name):
+				problem = "unknown wall texture '%s'"
+			}
+			if problem != "" {
+				scriptProblems = append(scriptProblems, scriptProblem{n.line, fmt.Sprintf("script '%s' line %d: ", mapFile.Script, n.line) + fmt.Sprintf(problem, name)})
+			}
+		})
+		// Functions are visited in any order
+		sort.Slice(scriptProblems, func(i, j int) bool { return scriptProblems[i].line < scriptProblems[j].line })
+		for _, p := range scriptProblems {
+			problems = append(problems, p.text)
+		}
+	}
+
+	for i, mt := range mapFile.Triggers {
+		reportTrigger := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("trigger %d: ", i+1)+fmt.Sprintf(format, args...))
+		}
+		t, err := parseTrigger(mt)
+		if err != nil {
+			reportTrigger("%v", err)
+			continue
+		}
+		if t.item != "" && itemDefs[t.item] == nil {
+			reportTrigger("unknown item '%s'", t.item)
+		}
+		for _, fn := range t.scriptCalls() {
+			if script == nil || script.funcs[fn] == nil {
+				reportTrigger("calls '%s' which isn't in the level's script", fn)
+			}
+		}
+		for _, cell := range t.cells() {
+			if !inBounds(cell[0], cell[1]) {
+				reportTrigger("%d,%d is outside the map", cell[0], cell[1])
+			}
+		}
+
+		for _, action := range t.actions {
+			switch action[0] {
+			case "sound":
+				if _, err := os.Stat("./sounds/" + action[1] + ".wav"); err != nil {
+					reportTrigger("unknown sound '%s'", action[1])
+				}
+			case "spawn":
+				if monsterDefs[action[1]] == nil {
+					reportTrigger("spawns unknown monster '%s'", action[1])
+				}
+			}
+
+			for _, value := range action[1:] {
+				cell, ok := parseCell(value)
+				if !ok || !inBounds(cell[0], cell[1]) {
+					continue
+				}
+				target := grid[cell[0]][cell[1]]
+				switch action[0] {
+				case "open", "close":
+					if target == nil || target.Type != "d" {
+						reportTrigger("%s target %d,%d is not a door", action[0], cell[0], cell[1])
+					}
+				case "spawn", "teleport":
+					if target != nil && (target.Type == "w" || target.Type == "d") {
+						reportTrigger("%s target %d,%d is a wall or door", action[0], cell[0], cell[1])
+					}
+				}
+			}
+		}
+	}
+
+	// Flood fill from the player, through anything that could be opened,
+	// if we reach the edge of the map then the player could walk out of it.
+	// Walls can be left out on some difficulties, so each one is checked
+	if len(players) > 0 {
+		openOn := map[[2]int][]string{}
+		edges := [][2]int{}
+		for _, d := range difficulties {
+			grid := mapFile.gridOn(d.name)
+			visited := map[[2]int]bool{}
+			queue := [][2]int{{players[0].X, players[0].Y}}
+			for len(queue) > 0 {
+				pos := queue[0]
+				queue = queue[1:]
+				if visited[pos] {
+					continue
+				}
+				visited[pos] = true
+
+				x, y := pos[0], pos[1]
+				if x == 0 || y == 0 || x == mapFile.Width-1 || y == mapFile.Height-1 {
+					if len(openOn[pos]) == 0 {
+						edges = append(edges, pos)
+					}
+					openOn[pos] = append(openOn[pos], d.name)
+					continue
+				}
+
+				for _, n := range [][2]int{{x + 1, y}, {x - 1, y}, {x, y + 1}, {x, y - 1}} {
+					cell := grid[n[0]][n[1]]
+					if cell != nil && cell.Type == "w" && !openable[n] {
+						continue
+					}
+					queue = append(queue, n)
+				}
+			}
+		}
+
+		for _, pos := range edges {
+			if len(openOn[pos]) == len(difficulties) {
+				report(pos[0], pos[1], "map edge is open, the player can leave the map")
+			} else {
+				report(pos[0], pos[1], "map edge is open on %s, the player can leave the map", strings.Join(openOn[pos], ", "))
+			}
+		}
+	}
+
+	return problems
+}
+
+// ===========================================================
+// Check the monster definitions refer to images & items that exist
+// ===========================================================
+func validateMonsterDefs() []string {
+	problems := []string{}
+	report := func(def *MonsterDef, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", def.name)+fmt.Sprintf(format, args...))
+	}
+
+	for _, name := range monsterNames() {
+		// Frame & dead images are checked when the definitions are loaded
+		def := monsterDefs[name]
+		if def.Projectile != nil && !imageExists("effects/"+def.Projectile.Kind) {
+			report(def, "unknown projectile '%s'", def.Projectile.Kind)
+		}
+		for _, drop := range def.Drops {
+			if itemDefs[drop.Item] == nil || isFurniture(drop.Item) {
+				report(def, "can't drop item '%s'", drop.Item)
+			}
+		}
+	}
+
+	return problems
+}
+
+// ===========================================================
+// Check the item definitions refer to images & sounds that exist
+// ===========================================================
+func validateItemDefs() []string {
+	problems := []string{}
+	report := func(def *ItemDef, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", def.name)+fmt.Sprintf(format, args...))
+	}
+
+	for _, name := range itemNames() {
+		def := itemDefs[name]
+		if !imageExists("items/" + name) {
+			report(def, "no image items/%s", name)
+		}
+		if def.Icon != "" && !imageExists(def.Icon) {
+			report(def, "unknown HUD icon '%s'", def.Icon)
+		}
+		if def.Sound != "" {
+			if _, err := os.Stat("./sounds/" + def.Sound + ".wav"); err != nil {
+				report(def, "unknown sound '%s'", def.Sound)
+			}
+		}
+		if def.Effect == effectBuff && def.Duration <= 0 {
+			report(def, "buff has no duration")
+		}
+		if def.Effect == effectWeapon && weaponDefs[def.Weapon] == nil {
+			report(def, "unknown weapon '%s'", def.Weapon)
+		}
+	}
+
+	return problems
+}
+
+// ===========================================================
+// Check the weapon definitions refer to images that exist
+// ===========================================================
+func validateWeaponDefs() []string {
+	problems := []string{}
+	report := func(def *WeaponDef, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: ", def.name)+fmt.Sprintf(format, args...))
+	}
+
+	slots := map[int]string{}
+	for _, def := range weaponOrder {
+		if !imageExists(def.Hud) {
+			report(def, "unknown HUD image '%s'", def.Hud)
+		}
+		if !def.Melee && !imageExists("effects/"+def.Projectile) {
+			report(def, "unknown projectile '%s'", def.Projectile)
+		}
+		if other, ok := slots[def.Slot]; ok {
+			report(def, "slot %d is also used by '%s'", def.Slot, other)
+		}
+		slots[def.Slot] = def.name
+	}
+	if startWeapon() == "" {
+		problems = append(problems, "no weapons have start set, the player would be unarmed")
+	}
+
+	return problems
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEdgeOnDifficulty(t *testing.T) {
+	path := writeTestMap(t, testMap([]string{
+		"#####",
+		"#P.X#",
+		"#####",
+	}, &MapFileCell{X: 2, Y: 0, Type: "w", Value: "brick_gray_1", Difficulties: []string{"easy", "normal"}}))
+
+	problems := validateMap(path)
+	if len(problems) != 1 || !strings.Contains(problems[0], "2,0: map edge is open on hard, nightmare") {
+		t.Errorf("expected the edge to be open on hard & nightmare only, got %v", problems)
+	}
+}
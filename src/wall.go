@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"strings"
 
 	"github.com/hajimehoshi/ebiten/v2"
 )
@@ -15,6 +14,8 @@ type Wall struct {
 	seen       bool
 	isDoor     bool
 	invisible  bool
+	door       *Door   // Only set for doors
+	sw         *Switch // Only set for switches
 
 	actionFunc func(g *Game)
 }
@@ -24,7 +25,7 @@ func (w *Wall) getCenter() (float64, float64) {
 }
 
 func newWall(x, y int, kind string) *Wall {
-	if _, ok := imageCache["walls/"+kind]; !ok {
+	if !imageExists("walls/" + kind) {
 		log.Fatalf("ERROR! Wall image not found: %s", kind)
 	}
 
@@ -39,45 +40,7 @@ func newWall(x, y int, kind string) *Wall {
 	}
 }
 
-func newDoor(x, y int, kind string) *Wall {
-	door := &Wall{
-		x:      x,
-		y:      y,
-		image:  imageCache["doors/"+kind],
-		isDoor: true,
-
-		// Default is a locked door
-		actionFunc: func(g *Game) {
-			playSound("locked", 1.0, false)
-		},
-	}
-
-	// Basic doors can just be opened
-	if kind == "basic" {
-		door.actionFunc = func(g *Game) {
-			game.mapdata[x][y] = nil
-			playSound("door_open", 0.4, false)
-		}
-	}
-
-	if strings.HasPrefix(kind, "key") {
-		door.actionFunc = func(g *Game) {
-			count, holding := g.player.holding[kind]
-			if holding && count > 0 {
-				game.mapdata[x][y] = nil
-				playSound("unlock", 1.0, false)
-				g.player.holding[kind]--
-			} else {
-				playSound("locked", 1.0, false)
-			}
-		}
-	}
-
-	return door
-}
-
 func newSecretWall(x, y int, kind string) *Wall {
-	game.stats.secretsTotal++
 	return &Wall{
 		x:     x,
 		y:     y,
@@ -85,35 +48,10 @@ func newSecretWall(x, y int, kind string) *Wall {
 
 		// Remove this wall
 		actionFunc: func(g *Game) {
-			game.mapdata[x][y] = nil
+			g.mapdata[x][y] = nil
+			g.stats.doorsOpened++
 			playSound("secret", 1.0, false)
-			game.stats.secretsFound++
-		},
-	}
-}
-
-func newSwitchWall(x, y int, kind string, tx, ty int) *Wall {
-	return &Wall{
-		x:          x,
-		y:          y,
-		image:      imageCache["walls/"+kind],
-		decoration: imageCache["decoration/switch"],
-
-		// Remove a wall somewhere else
-		actionFunc: func(g *Game) {
-			wall := game.mapdata[x][y]
-			if wall.metadata[0] == "pressed" {
-				playSound("grunt", 1.0, false)
-				return
-			}
-			game.mapdata[tx][ty] = nil
-			playSound("switch", 1.0, false)
-			wall.decoration = imageCache["decoration/switch-1"]
-			wall.metadata[0] = "pressed"
-		},
-
-		metadata: []string{
-			"not_pressed",
+			g.stats.secretsFound++
 		},
 	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+const weaponDefsFile = "./data/weapons.json"
+
+// All weapons, keyed by name, items with the weapon effect refer to these
+var weaponDefs map[string]*WeaponDef
+
+// Weapons sorted by slot, used when switching weapons
+var weaponOrder []*WeaponDef
+
+type WeaponDef struct {
+	name       string
+	Title      string  `json:"title"`
+	Slot       int     `json:"slot"`  // Number key used to select it
+	Start      bool    `json:"start"` // The player starts every level with this
+	Melee      bool    `json:"melee"` // Hits monsters in front of the player rather than firing projectiles
+	Damage     int     `json:"damage"`
+	Mana       int     `json:"mana"`  // Cost of each attack
+	Rate       int     `json:"rate"`  // Ticks between attacks
+	Range      float64 `json:"range"` // Reach of melee weapons in cells
+	Projectile string  `json:"projectile"`
+	Speed      float64 `json:"speed"` // Projectile speed in cells per tick
+	Alpha      float64 `json:"alpha"`
+	Count      int     `json:"count"`    // Projectiles fired each attack
+	Spread     float64 `json:"spread"`   // Angle between projectiles in radians
+	Piercing   bool    `json:"piercing"` // Projectiles pass through monsters
+	Sound      string  `json:"sound"`
+	Hud        string  `json:"hud"` // Image drawn at the bottom of the screen
+}
+
+func newWeaponDef(name string) *WeaponDef {
+	return &WeaponDef{
+		name:  name,
+		Title: name,
+		Rate:  10,
+		Range: 1,
+		Speed: 0.2,
+		Alpha: 1,
+		Count: 1,
+		Hud:   "hud/weapon_0",
+	}
+}
+
+// ===========================================================
+// Load the weapon definitions file
+// ===========================================================
+func loadWeaponDefs() error {
+	data, err := ioutil.ReadFile(weaponDefsFile)
+	if err != nil {
+		return err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("weapon definitions are not valid JSON: %v", err)
+	}
+
+	weaponDefs = map[string]*WeaponDef{}
+	weaponOrder = []*WeaponDef{}
+	for name, rawDef := range raw {
+		def := newWeaponDef(name)
+		if err := json.Unmarshal(rawDef, def); err != nil {
+			return fmt.Errorf("weapon '%s' is not valid: %v", name, err)
+		}
+		if def.Count < 1 {
+			def.Count = 1
+		}
+		if !def.Melee && def.Projectile == "" {
+			log.Printf("WARNING! Weapon '%s' has no projectile", name)
+		}
+		weaponDefs[name] = def
+		weaponOrder = append(weaponOrder, def)
+	}
+
+	sort.Slice(weaponOrder, func(i, j int) bool {
+		if weaponOrder[i].Slot == weaponOrder[j].Slot {
+			return weaponOrder[i].name < weaponOrder[j].name
+		}
+		return weaponOrder[i].Slot < weaponOrder[j].Slot
+	})
+
+	log.Printf("Loaded %d weapon definitions", len(weaponDefs))
+	return nil
+}
+
+// The weapon the player starts with in their hands
+func startWeapon() string {
+	for _, def := range weaponOrder {
+		if def.Start {
+			return def.name
+		}
+	}
+	return ""
+}